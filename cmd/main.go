@@ -1,45 +1,315 @@
 package main
 
 import (
+	"../pkg/config"
 	"../pkg/context"
+	"../pkg/lifecycle"
 	"../pkg/logger"
 	"../pkg/memstore"
 	"../pkg/server"
 	"../pkg/workpool"
+	gocontext "context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-const (
-	MaxWorker = 3  //os.Getenv("MAX_WORKERS")
-	MaxQueue  = 20 //os.Getenv("MAX_QUEUE")
-)
+//PendingFile keeps the works left in the work queue at shutdown, it is in data directory of durable storage
+const PendingFile = "pending-works.json"
+
+//DeadLetterFile keeps the dead-lettered works between runs, it is in data directory of durable storage
+const DeadLetterFile = "dead-letters.json"
+
+//ConfigWatchInterval is how often the config file is checked for changes
+const ConfigWatchInterval = 2 * time.Second
 
 func main() {
 
+	//load configuration from config file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	//create async logger
-	asyncLogger := logger.CreateAsyncLogger()
+	asyncLogger, err := createLogger(cfg.Log)
+	if err != nil {
+		log.Fatal("Cannot create logger: ", err)
+	}
 
-	storage := memstore.CreateInMemDB()
-	storage.SetLogger(asyncLogger)
+	//configuration is reloaded when the config file changes or on SIGHUP
+	store := config.NewStore(cfg, os.Args[1:], asyncLogger)
+	store.Subscribe(func(old *config.Config, new *config.Config) {
+		reloadLogger(asyncLogger, old.Log, new.Log)
+	})
+	stopWatch := make(chan struct{})
+	go store.Watch(ConfigWatchInterval, stopWatch)
+
+	//reopen log files and reload configuration on SIGHUP so that external tools can move log files
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := asyncLogger.Reopen(); err != nil {
+				asyncLogger.Log(logger.ERROR, "Cannot reopen log files: ", err.Error())
+			}
+			store.Reload()
+		}
+	}()
+
+	storage, err := createStorage(cfg.Storage, asyncLogger)
+	if err != nil {
+		asyncLogger.Log(logger.ERROR, "Cannot open storage: ", err.Error())
+		abortStart(asyncLogger, cfg.Server.ShutdownTimeout)
+	}
 
 	//create application context
 	appContext := context.AppContext{
 		Storage: storage,
 		Logger:  asyncLogger,
+		Config:  store,
 	}
 
 	//initialize dispatcher and pools
-	workQueue := make(chan workpool.WorkRequest, MaxQueue)
-	dispatcher := workpool.NewDispatcher(workQueue, MaxWorker, &appContext)
+	jobs := workpool.NewJobRegistry()
+	admission := workpool.NewAdmission(cfg.WorkPool.QueueSize, cfg.WorkPool.MaxInFlight, cfg.WorkPool.EnqueueTimeout)
+	dispatcher := workpool.NewDispatcher(admission, cfg.WorkPool.Workers, jobs, &appContext)
+	closeStorage := func(ctx gocontext.Context) error {
+		return storage.Close()
+	}
+	if err := restoreDeadLetters(pendingDir(cfg.Storage), dispatcher.DeadLetters, jobs, asyncLogger); err != nil {
+		asyncLogger.Log(logger.ERROR, "Cannot start: ", err.Error())
+		abortStart(asyncLogger, cfg.Server.ShutdownTimeout, closeStorage)
+	}
 	dispatcher.StartDispatcher()
+	if err := resubmitPending(pendingDir(cfg.Storage), admission, jobs, asyncLogger); err != nil {
+		//pending file is kept, so works are resubmitted again on next start
+		asyncLogger.Log(logger.ERROR, "Cannot start: ", err.Error())
+		abortStart(asyncLogger, cfg.Server.ShutdownTimeout, func(ctx gocontext.Context) error {
+			_, err := dispatcher.Stop(ctx)
+			return err
+		}, closeStorage)
+	}
 
 	//create server
-	server := server.CreateServer(&appContext, workQueue)
+	server := server.CreateServer(&appContext, admission, jobs, dispatcher.Metrics, dispatcher.DeadLetters)
+
+	httpServer := &http.Server{Addr: cfg.Server.Listen, Handler: server.Routers}
+	go func() {
+		var err error
+		if cfg.Server.TLS.Enabled() {
+			asyncLogger.Log(logger.INFO, "Listening ", cfg.Server.Listen, " with TLS...")
+			err = httpServer.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		} else {
+			asyncLogger.Log(logger.INFO, "Listening ", cfg.Server.Listen, "...")
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			asyncLogger.Log(logger.FATAL, err.Error())
+		}
+	}()
 
-	http.Handle("/", server.Routers)
-	asyncLogger.Log(logger.INFO, "Listening localhost 8080...")
-	err := http.ListenAndServe("localhost:8080", server.Routers)
+	//components are stopped in this order on SIGINT/SIGTERM or FATAL message, logger is stopped last
+	manager := lifecycle.NewManager(asyncLogger, cfg.Server.ShutdownTimeout)
+	manager.OnStop("config watcher", func(ctx gocontext.Context) error {
+		close(stopWatch)
+		return nil
+	})
+	manager.OnStop("http server", httpServer.Shutdown)
+	manager.OnStop("work pool", func(ctx gocontext.Context) error {
+		leftovers, err := dispatcher.Stop(ctx)
+		savePending(pendingDir(cfg.Storage), leftovers, asyncLogger)
+		saveDeadLetters(pendingDir(cfg.Storage), dispatcher.DeadLetters.List(), asyncLogger)
+		return err
+	})
+	manager.OnStop("storage", func(ctx gocontext.Context) error {
+		return storage.Close()
+	})
+	os.Exit(manager.Wait())
+}
+
+//createLogger creates the logger with the configured buffer, levels, format and sinks.
+//Configuration is already validated so names can be parsed without errors.
+func createLogger(cfg config.LogConfig) (*logger.AsyncLogger, error) {
+	overflow, _ := logger.ParseOverflowPolicy(cfg.Overflow)
+	asyncLogger := logger.CreateAsyncLoggerWithOptions(logger.Options{
+		BufferSize: cfg.BufferSize,
+		Overflow:   overflow,
+	})
+
+	level, _ := logger.ParseLevel(cfg.Level)
+	asyncLogger.SetLevel(level)
+	for component, name := range cfg.Components {
+		level, _ := logger.ParseLevel(name)
+		asyncLogger.SetComponentLevel(component, level)
+	}
+	encoder, _ := logger.ParseEncoder(cfg.Format)
+	asyncLogger.SetEncoder(encoder)
+
+	//logs of all levels go to the log file if there is one, otherwise to Stdout/Stderr
+	if cfg.File != "" {
+		fileSink, err := logger.NewFileSink(cfg.File, logger.FileSinkOptions{
+			MaxSize:    int64(cfg.MaxSizeMB) << 20,
+			Daily:      cfg.Daily,
+			Compress:   cfg.Compress,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, level := range []logger.LogLevel{logger.INFO, logger.WARNING, logger.ERROR, logger.FATAL} {
+			asyncLogger.SetSink(level, fileSink)
+		}
+	}
+	return asyncLogger, nil
+}
+
+//reloadLogger applies the changed levels and format so that levels changed through admin API are kept
+//unless they are changed in configuration too. Components removed from configuration fall back to the minimum level.
+func reloadLogger(asyncLogger *logger.AsyncLogger, old config.LogConfig, new config.LogConfig) {
+	if old.Level != new.Level {
+		level, _ := logger.ParseLevel(new.Level)
+		asyncLogger.SetLevel(level)
+	}
+	for component := range old.Components {
+		if _, ok := new.Components[component]; !ok {
+			asyncLogger.ResetComponentLevel(component)
+		}
+	}
+	for component, name := range new.Components {
+		if old.Components[component] != name {
+			level, _ := logger.ParseLevel(name)
+			asyncLogger.SetComponentLevel(component, level)
+		}
+	}
+	if old.Format != new.Format {
+		encoder, _ := logger.ParseEncoder(new.Format)
+		asyncLogger.SetEncoder(encoder)
+	}
+}
+
+//createStorage creates the configured storage backend which logs to the given logger
+func createStorage(cfg config.StorageConfig, asyncLogger *logger.AsyncLogger) (memstore.Storage, error) {
+	if cfg.Backend == config.BackendMemory {
+		storage := memstore.CreateInMemDB()
+		storage.SetLogger(asyncLogger)
+		return storage, nil
+	}
+	fsync, _ := memstore.ParseFsyncPolicy(cfg.Fsync)
+	return memstore.CreateDurableDB(cfg.DataDir, memstore.DurableOptions{
+		Fsync:         fsync,
+		FsyncInterval: cfg.FsyncInterval,
+		SnapshotEvery: cfg.SnapshotEvery,
+		Logger:        asyncLogger,
+	})
+}
+
+//pendingDir is where pending works are kept, empty for in-memory storage
+func pendingDir(cfg config.StorageConfig) string {
+	if cfg.Backend == config.BackendMemory {
+		return ""
+	}
+	return cfg.DataDir
+}
+
+//savePending reports the works which could not be processed before shutdown and keeps them
+//in data directory so that they are submitted again on the next start
+func savePending(dir string, works []workpool.WorkRequest, asyncLogger *logger.AsyncLogger) {
+	for _, work := range works {
+		asyncLogger.LogFields(logger.WARNING, "Work has not been processed before shutdown",
+			logger.F("job", work.ID), logger.F("op", work.Op), logger.F("key", work.Key))
+	}
+	if dir == "" || len(works) == 0 {
+		return
+	}
+	if err := workpool.SavePending(filepath.Join(dir, PendingFile), works); err != nil {
+		asyncLogger.Log(logger.ERROR, "Pending works cannot be saved: ", err.Error())
+		return
+	}
+	asyncLogger.Log(logger.WARNING, strconv.Itoa(len(works)), " pending works have been saved to ", PendingFile)
+}
+
+//resubmitPending submits the works saved by the previous run
+func resubmitPending(dir string, admission *workpool.Admission, jobs *workpool.JobRegistry, asyncLogger *logger.AsyncLogger) error {
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, PendingFile)
+	works, err := workpool.LoadPending(path)
+	if err != nil {
+		return fmt.Errorf("pending works cannot be loaded: %v", err)
+	}
+	if len(works) == 0 {
+		return nil
+	}
+	if err := workpool.Resubmit(admission, jobs, works); err != nil {
+		return fmt.Errorf("pending works cannot be resubmitted: %v", err)
+	}
+	os.Remove(path)
+	asyncLogger.Log(logger.INFO, strconv.Itoa(len(works)), " pending works of previous run have been resubmitted")
+	return nil
+}
+
+//saveDeadLetters keeps the dead-lettered works in data directory so that they can still be replayed after restart
+func saveDeadLetters(dir string, letters []workpool.DeadLetter, asyncLogger *logger.AsyncLogger) {
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, DeadLetterFile)
+	if len(letters) == 0 {
+		os.Remove(path)
+		return
+	}
+	if err := workpool.SaveDeadLetters(path, letters); err != nil {
+		asyncLogger.Log(logger.ERROR, "Dead letters cannot be saved: ", err.Error())
+		return
+	}
+	asyncLogger.Log(logger.WARNING, strconv.Itoa(len(letters)), " dead letters have been saved to ", DeadLetterFile)
+}
+
+//restoreDeadLetters puts the dead letters saved by the previous run back into the dead-letter queue
+func restoreDeadLetters(dir string, deadLetters *workpool.DeadLetterQueue, jobs *workpool.JobRegistry, asyncLogger *logger.AsyncLogger) error {
+	if dir == "" {
+		return nil
+	}
+	letters, err := workpool.LoadDeadLetters(filepath.Join(dir, DeadLetterFile))
 	if err != nil {
-		asyncLogger.Log(logger.FATAL, err.Error())
+		return fmt.Errorf("dead letters cannot be loaded: %v", err)
+	}
+	for _, letter := range letters {
+		jobs.Add(gocontext.Background(), letter.Work.ID)
+		jobs.DeadLetter(letter.Work.ID, errors.New(letter.Error))
+		deadLetters.Add(letter)
+	}
+	if len(letters) > 0 {
+		asyncLogger.Log(logger.INFO, strconv.Itoa(len(letters)), " dead letters of previous run have been restored")
+	}
+	return nil
+}
+
+//abortStart stops the components started so far in the given order, closes the logger last and exits with 1.
+//Unlike log.Fatal, it lets storage flush its log and the logger write the reason of the failure.
+func abortStart(asyncLogger *logger.AsyncLogger, timeout time.Duration, stops ...lifecycle.StopFunc) {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), timeout)
+	for _, stop := range stops {
+		if err := stop(ctx); err != nil {
+			asyncLogger.Log(logger.ERROR, "Stopping failed: ", err.Error())
+		}
+	}
+	cancel()
+
+	logCtx, logCancel := gocontext.WithTimeout(gocontext.Background(), lifecycle.LoggerTimeout)
+	if err := asyncLogger.Close(logCtx); err != nil {
+		os.Stderr.WriteString("Logs cannot be flushed: " + err.Error() + "\n")
 	}
+	logCancel()
+	os.Exit(1)
 }
@@ -0,0 +1,488 @@
+/*
+Package config loads the configuration of the application.
+Values are taken from the following sources, each one overriding the previous:
+
+	defaults      see Default
+	config file   YAML file given by -config flag or CONFIG_FILE environment variable
+	environment   e.g. LISTEN_ADDR, MAX_WORKERS, LOG_LEVEL, DATA_DIR (see envVars)
+	flags         e.g. -listen, -workers, -log-level, -data-dir (only the flags given on command line)
+
+Loaded configuration is validated and shared with all packages through AppContext.
+Some settings can be changed at runtime by editing the config file or sending SIGHUP, see Store.
+
+Sample config file:
+
+	server:
+	  listen: 0.0.0.0:8443
+	  tls:
+	    certFile: /etc/appmetadata/tls.crt
+	    keyFile: /etc/appmetadata/tls.key
+	  adminKeys: [ops-team-key]
+	workpool:
+	  workers: 8
+	  queueSize: 200
+	log:
+	  level: WARNING
+	  format: json
+	  file: /var/log/appmetadata/app.log
+	storage:
+	  backend: durable
+	  dataDir: /var/lib/appmetadata
+	validation:
+	  allowedLicenses: [MIT, Apache-2.0]
+*/
+package config
+
+import (
+	"../logger"
+	"../memstore"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Storage backends
+const (
+	BackendMemory  = "memory"
+	BackendDurable = "durable"
+)
+
+//Config is the configuration of the application
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	WorkPool   WorkPoolConfig   `yaml:"workpool"`
+	Log        LogConfig        `yaml:"log"`
+	Storage    StorageConfig    `yaml:"storage"`
+	Validation ValidationConfig `yaml:"validation"`
+
+	//path of the config file the configuration is loaded from, empty if there is none
+	path string
+}
+
+//Path returns the config file the configuration is loaded from
+func (c *Config) Path() string {
+	return c.path
+}
+
+//ServerConfig defines where and how HTTP server listens.
+//AdminKeys are the API keys given by X-API-Key header which can use the admin endpoints, e.g. reading
+//and replaying dead letters. Admin endpoints cannot be used if there is none.
+type ServerConfig struct {
+	Listen          string          `yaml:"listen"`
+	TLS             TLSConfig       `yaml:"tls"`
+	ShutdownTimeout time.Duration   `yaml:"shutdownTimeout"`
+	RateLimit       RateLimitConfig `yaml:"rateLimit"`
+	AdminKeys       []string        `yaml:"adminKeys"`
+}
+
+//RateLimitConfig limits the requests accepted by the server. Zero RequestsPerSecond means no limit.
+//Burst is the number of requests accepted at once, RequestsPerSecond if not given.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+//TLSConfig enables HTTPS when both certificate and key files are given
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+//Enabled reports whether server should listen with TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+//WorkPoolConfig defines the size of the work pool. Work queue has a lane of QueueSize for each priority.
+//MaxInFlight is QueueSize of every lane + Workers (or Autoscale.MaxWorkers if it is larger) if not given.
+//JobTimeout limits the processing of a job, clients can ask for a shorter one by X-Job-Timeout header.
+type WorkPoolConfig struct {
+	Workers        int             `yaml:"workers"`
+	QueueSize      int             `yaml:"queueSize"`
+	MaxInFlight    int             `yaml:"maxInFlight"`
+	EnqueueTimeout time.Duration   `yaml:"enqueueTimeout"`
+	Autoscale      AutoscaleConfig `yaml:"autoscale"`
+	Retry          RetryConfig     `yaml:"retry"`
+	DeadLetterSize int             `yaml:"deadLetterSize"`
+	JobTimeout     time.Duration   `yaml:"jobTimeout"`
+	Priority       PriorityConfig  `yaml:"priority"`
+}
+
+//PriorityLanes are the names of the priority lanes of the work queue, from the highest one
+var PriorityLanes = []string{"high", "normal", "bulk"}
+
+//PriorityConfig defines how works are taken from the priority lanes. Dispatcher serves the lanes in proportion
+//to their Weights so that lower lanes still make progress while higher ones are busy.
+//APIKeys maps the API keys given by X-API-Key header to the highest lane their requests can use,
+//requests without a configured key can use normal and bulk lanes.
+type PriorityConfig struct {
+	Weights PriorityWeights   `yaml:"weights"`
+	APIKeys map[string]string `yaml:"apiKeys"`
+}
+
+//PriorityWeights are the weights of the priority lanes
+type PriorityWeights struct {
+	High   int `yaml:"high"`
+	Normal int `yaml:"normal"`
+	Bulk   int `yaml:"bulk"`
+}
+
+//RetryConfig defines how many times a work failed with a transient error is attempted and how long
+//to wait between attempts. Wait starts with InitialBackoff and doubles up to MaxBackoff, with jitter.
+//Works still failing after MaxAttempts are moved to the dead-letter queue.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+//AutoscaleConfig lets the work pool grow and shrink between MinWorkers and MaxWorkers, Workers is the initial size.
+//Autoscaling is disabled if MaxWorkers is zero. Every Interval, pool grows if there are queued works waiting longer
+//than ScaleUpWait or more queued works than workers, and shrinks if workers have been idle for ScaleDownDelay.
+type AutoscaleConfig struct {
+	MinWorkers     int           `yaml:"minWorkers"`
+	MaxWorkers     int           `yaml:"maxWorkers"`
+	Interval       time.Duration `yaml:"interval"`
+	ScaleUpWait    time.Duration `yaml:"scaleUpWait"`
+	ScaleDownDelay time.Duration `yaml:"scaleDownDelay"`
+}
+
+//Enabled reports whether the work pool is autoscaled
+func (a AutoscaleConfig) Enabled() bool {
+	return a.MaxWorkers > 0
+}
+
+//LogConfig defines levels, format, buffering and the optional log file.
+//Logs are written to Stdout/Stderr if File is empty.
+type LogConfig struct {
+	Level      string            `yaml:"level"`
+	Components map[string]string `yaml:"components"`
+	Format     string            `yaml:"format"`
+	BufferSize int               `yaml:"bufferSize"`
+	Overflow   string            `yaml:"overflow"`
+	File       string            `yaml:"file"`
+	MaxSizeMB  int               `yaml:"maxSizeMB"`
+	Daily      bool              `yaml:"daily"`
+	Compress   bool              `yaml:"compress"`
+	MaxBackups int               `yaml:"maxBackups"`
+	MaxAge     time.Duration     `yaml:"maxAge"`
+}
+
+//ValidationConfig defines the rules applied to application metadata in addition to the mandatory fields.
+//Empty AllowedLicenses means any license and zero MaxDescriptionLength means no limit.
+type ValidationConfig struct {
+	AllowedLicenses      []string `yaml:"allowedLicenses"`
+	MaxDescriptionLength int      `yaml:"maxDescriptionLength"`
+	AllowPrerelease      bool     `yaml:"allowPrerelease"`
+}
+
+//StorageConfig selects the storage backend. Durable backend keeps its files in DataDir.
+type StorageConfig struct {
+	Backend       string        `yaml:"backend"`
+	DataDir       string        `yaml:"dataDir"`
+	Fsync         string        `yaml:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsyncInterval"`
+	SnapshotEvery int           `yaml:"snapshotEvery"`
+}
+
+//Default returns the configuration used when nothing else is given
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:          "localhost:8080",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		WorkPool: WorkPoolConfig{
+			Workers:        3,
+			QueueSize:      20,
+			EnqueueTimeout: 500 * time.Millisecond,
+			Autoscale: AutoscaleConfig{
+				MinWorkers:     1,
+				Interval:       time.Second,
+				ScaleUpWait:    100 * time.Millisecond,
+				ScaleDownDelay: 30 * time.Second,
+			},
+			Retry: RetryConfig{
+				MaxAttempts:    5,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     10 * time.Second,
+			},
+			DeadLetterSize: 1000,
+			JobTimeout:     30 * time.Second,
+			Priority: PriorityConfig{
+				Weights: PriorityWeights{High: 6, Normal: 3, Bulk: 1},
+			},
+		},
+		Log: LogConfig{
+			Level:      "INFO",
+			Format:     "text",
+			BufferSize: logger.DefaultBufferSize,
+			Overflow:   logger.Block.String(),
+			MaxSizeMB:  100,
+			Daily:      true,
+			Compress:   true,
+			MaxBackups: 10,
+			MaxAge:     7 * 24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			Backend:       BackendDurable,
+			DataDir:       "data",
+			Fsync:         memstore.FsyncInterval.String(),
+			FsyncInterval: time.Second,
+			SnapshotEvery: 1000,
+		},
+		Validation: ValidationConfig{
+			AllowPrerelease: true,
+		},
+	}
+}
+
+//Load builds the configuration from defaults, config file, environment and command line arguments
+//(without program name) and validates it.
+func Load(args []string) (*Config, error) {
+	fs, flags := newFlagSet()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	if *flags.configFile != "" {
+		path = *flags.configFile
+	}
+
+	c := Default()
+	c.path = path
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+	c.loadFlags(fs, flags)
+
+	if c.WorkPool.MaxInFlight == 0 {
+		workers := c.WorkPool.Workers
+		if c.WorkPool.Autoscale.MaxWorkers > workers {
+			workers = c.WorkPool.Autoscale.MaxWorkers
+		}
+		c.WorkPool.MaxInFlight = c.WorkPool.QueueSize*len(PriorityLanes) + workers
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//loadFile overrides the configuration by the values in YAML file, unknown keys are rejected
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+//envVars maps environment variables to the configuration values they override
+func (c *Config) envVars() map[string]interface{} {
+	return map[string]interface{}{
+		"LISTEN_ADDR":           &c.Server.Listen,
+		"TLS_CERT_FILE":         &c.Server.TLS.CertFile,
+		"TLS_KEY_FILE":          &c.Server.TLS.KeyFile,
+		"SHUTDOWN_TIMEOUT":      &c.Server.ShutdownTimeout,
+		"MAX_WORKERS":           &c.WorkPool.Workers,
+		"MAX_QUEUE":             &c.WorkPool.QueueSize,
+		"MAX_IN_FLIGHT":         &c.WorkPool.MaxInFlight,
+		"ENQUEUE_TIMEOUT":       &c.WorkPool.EnqueueTimeout,
+		"MAX_ATTEMPTS":          &c.WorkPool.Retry.MaxAttempts,
+		"JOB_TIMEOUT":           &c.WorkPool.JobTimeout,
+		"AUTOSCALE_MIN_WORKERS": &c.WorkPool.Autoscale.MinWorkers,
+		"AUTOSCALE_MAX_WORKERS": &c.WorkPool.Autoscale.MaxWorkers,
+		"LOG_LEVEL":             &c.Log.Level,
+		"LOG_FORMAT":            &c.Log.Format,
+		"LOG_FILE":              &c.Log.File,
+		"STORAGE_BACKEND":       &c.Storage.Backend,
+		"DATA_DIR":              &c.Storage.DataDir,
+		"FSYNC":                 &c.Storage.Fsync,
+	}
+}
+
+//loadEnv overrides the configuration by the environment variables which are set
+func (c *Config) loadEnv() error {
+	for name, target := range c.envVars() {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		var err error
+		switch target := target.(type) {
+		case *string:
+			*target = value
+		case *int:
+			*target, err = strconv.Atoi(value)
+		case *time.Duration:
+			*target, err = time.ParseDuration(value)
+		}
+		if err != nil {
+			return fmt.Errorf("environment variable %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+//flagValues are the values of command line flags
+type flagValues struct {
+	configFile  *string
+	listen      *string
+	tlsCert     *string
+	tlsKey      *string
+	workers     *int
+	queue       *int
+	maxInFlight *int
+	logLevel    *string
+	logFormat   *string
+	logFile     *string
+	storage     *string
+	dataDir     *string
+}
+
+func newFlagSet() (*flag.FlagSet, *flagValues) {
+	fs := flag.NewFlagSet("appmetadata", flag.ContinueOnError)
+	return fs, &flagValues{
+		configFile:  fs.String("config", "", "YAML config file"),
+		listen:      fs.String("listen", "", "listen address, e.g. localhost:8080"),
+		tlsCert:     fs.String("tls-cert", "", "TLS certificate file"),
+		tlsKey:      fs.String("tls-key", "", "TLS key file"),
+		workers:     fs.Int("workers", 0, "number of workers"),
+		queue:       fs.Int("queue", 0, "size of the work queue"),
+		maxInFlight: fs.Int("max-in-flight", 0, "maximum number of queued and running works"),
+		logLevel:    fs.String("log-level", "", "minimum log level: INFO, WARNING, ERROR or FATAL"),
+		logFormat:   fs.String("log-format", "", "log format: text, json or logfmt"),
+		logFile:     fs.String("log-file", "", "log file, logs are written to Stdout/Stderr if empty"),
+		storage:     fs.String("storage", "", "storage backend: memory or durable"),
+		dataDir:     fs.String("data-dir", "", "data directory of durable storage"),
+	}
+}
+
+//loadFlags overrides the configuration by the flags given on command line.
+//Flags which are not given do not override values of config file or environment.
+func (c *Config) loadFlags(fs *flag.FlagSet, flags *flagValues) {
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			c.Server.Listen = *flags.listen
+		case "tls-cert":
+			c.Server.TLS.CertFile = *flags.tlsCert
+		case "tls-key":
+			c.Server.TLS.KeyFile = *flags.tlsKey
+		case "workers":
+			c.WorkPool.Workers = *flags.workers
+		case "queue":
+			c.WorkPool.QueueSize = *flags.queue
+		case "max-in-flight":
+			c.WorkPool.MaxInFlight = *flags.maxInFlight
+		case "log-level":
+			c.Log.Level = *flags.logLevel
+		case "log-format":
+			c.Log.Format = *flags.logFormat
+		case "log-file":
+			c.Log.File = *flags.logFile
+		case "storage":
+			c.Storage.Backend = *flags.storage
+		case "data-dir":
+			c.Storage.DataDir = *flags.dataDir
+		}
+	})
+}
+
+//Validate checks the values of the configuration and returns all problems at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.Listen)
+	check(err == nil, "server.listen must be host:port")
+	check((c.Server.TLS.CertFile == "") == (c.Server.TLS.KeyFile == ""), "server.tls needs both certFile and keyFile")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.RateLimit.RequestsPerSecond >= 0 && c.Server.RateLimit.Burst >= 0, "server.rateLimit cannot be negative")
+	check(!contains(c.Server.AdminKeys, ""), "server.adminKeys cannot be empty")
+
+	check(c.WorkPool.Workers > 0, "workpool.workers must be positive")
+	check(c.WorkPool.QueueSize > 0, "workpool.queueSize must be positive")
+	check(c.WorkPool.MaxInFlight >= c.WorkPool.Workers, "workpool.maxInFlight must be at least workpool.workers")
+	check(c.WorkPool.EnqueueTimeout > 0, "workpool.enqueueTimeout must be positive")
+	if autoscale := c.WorkPool.Autoscale; autoscale.Enabled() {
+		check(autoscale.MinWorkers > 0, "workpool.autoscale.minWorkers must be positive")
+		check(autoscale.MinWorkers <= c.WorkPool.Workers && c.WorkPool.Workers <= autoscale.MaxWorkers,
+			"workpool.workers must be between workpool.autoscale.minWorkers and maxWorkers")
+		check(c.WorkPool.MaxInFlight >= autoscale.MaxWorkers, "workpool.maxInFlight must be at least workpool.autoscale.maxWorkers")
+		check(autoscale.Interval > 0 && autoscale.ScaleUpWait > 0 && autoscale.ScaleDownDelay > 0,
+			"workpool.autoscale durations must be positive")
+	}
+	check(c.WorkPool.Retry.MaxAttempts > 0, "workpool.retry.maxAttempts must be positive")
+	check(c.WorkPool.Retry.InitialBackoff > 0 && c.WorkPool.Retry.MaxBackoff >= c.WorkPool.Retry.InitialBackoff,
+		"workpool.retry.initialBackoff must be positive and not longer than maxBackoff")
+	check(c.WorkPool.DeadLetterSize > 0, "workpool.deadLetterSize must be positive")
+	check(c.WorkPool.JobTimeout > 0, "workpool.jobTimeout must be positive")
+	weights := c.WorkPool.Priority.Weights
+	check(weights.High > 0 && weights.Normal > 0 && weights.Bulk > 0, "workpool.priority.weights must be positive")
+	for _, lane := range c.WorkPool.Priority.APIKeys {
+		check(contains(PriorityLanes, lane), "workpool.priority.apiKeys must map to one of "+strings.Join(PriorityLanes, ", "))
+	}
+
+	_, err = logger.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be one of "+strings.Join(logger.LogLevelStr[:], ", "))
+	for component, level := range c.Log.Components {
+		_, err = logger.ParseLevel(level)
+		check(err == nil, "log.components."+component+" must be one of "+strings.Join(logger.LogLevelStr[:], ", "))
+	}
+	_, err = logger.ParseEncoder(c.Log.Format)
+	check(err == nil, "log.format must be text, json or logfmt")
+	_, err = logger.ParseOverflowPolicy(c.Log.Overflow)
+	check(err == nil, "log.overflow must be one of "+strings.Join(logger.OverflowPolicyStr[:], ", "))
+	check(c.Log.BufferSize > 0, "log.bufferSize must be positive")
+	check(c.Log.MaxSizeMB >= 0 && c.Log.MaxBackups >= 0 && c.Log.MaxAge >= 0, "log rotation limits cannot be negative")
+
+	check(c.Validation.MaxDescriptionLength >= 0, "validation.maxDescriptionLength cannot be negative")
+
+	switch c.Storage.Backend {
+	case BackendMemory:
+	case BackendDurable:
+		check(c.Storage.DataDir != "", "storage.dataDir is required for durable backend")
+		_, err = memstore.ParseFsyncPolicy(c.Storage.Fsync)
+		check(err == nil, "storage.fsync must be one of "+strings.Join(memstore.FsyncPolicyStr[:], ", "))
+		check(c.Storage.FsyncInterval > 0, "storage.fsyncInterval must be positive")
+		check(c.Storage.SnapshotEvery >= 0, "storage.snapshotEvery cannot be negative")
+	default:
+		check(false, "storage.backend must be memory or durable")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"../logger"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeConfig writes the YAML config file into a temporary directory and returns its path
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const precedenceConfig = `
+server:
+  listen: "localhost:9000"
+workpool:
+  workers: 4
+  queueSize: 20
+log:
+  level: WARNING
+  format: json
+`
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, precedenceConfig))
+	t.Setenv("MAX_WORKERS", "6")
+	t.Setenv("LOG_LEVEL", "ERROR")
+
+	c, err := Load([]string{"-workers", "8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		setting       string
+		value, expect interface{}
+	}{
+		//flag over environment over file
+		{"workpool.workers", c.WorkPool.Workers, 8},
+		//environment over file
+		{"log.level", c.Log.Level, "ERROR"},
+		//file over default
+		{"server.listen", c.Server.Listen, "localhost:9000"},
+		{"log.format", c.Log.Format, "json"},
+		//default
+		{"workpool.enqueueTimeout", c.WorkPool.EnqueueTimeout, Default().WorkPool.EnqueueTimeout},
+		//computed from the loaded values when it is not given
+		{"workpool.maxInFlight", c.WorkPool.MaxInFlight, 20*len(PriorityLanes) + 8},
+	}
+	for _, test := range tests {
+		if test.value != test.expect {
+			t.Errorf("%s is %v, expected %v", test.setting, test.value, test.expect)
+		}
+	}
+}
+
+func TestConfigFlagOverEnvironment(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfig(t, "workpool:\n  workers: 2\n"))
+	path := writeConfig(t, "workpool:\n  workers: 5\n")
+
+	c, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.WorkPool.Workers != 5 || c.Path() != path {
+		t.Fatalf("configuration is loaded from %s with %d workers, expected %s given by flag", c.Path(), c.WorkPool.Workers, path)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		problems []string
+	}{
+		{"unknown key in file", "workpool:\n  wokers: 2\n", nil, nil, []string{"wokers"}},
+		{"malformed environment variable", "", map[string]string{"MAX_QUEUE": "ten"}, nil, []string{"MAX_QUEUE"}},
+		{"unknown flag", "", nil, []string{"-wrokers", "2"}, []string{"wrokers"}},
+		//every problem is reported at once, whichever source they come from
+		{"invalid values", "log:\n  level: VERBOSE\n", map[string]string{"STORAGE_BACKEND": "disk"}, []string{"-workers", "0"},
+			[]string{"log.level", "storage.backend", "workpool.workers must be positive"}},
+		{"durable storage without directory", "", nil, []string{"-storage", "durable", "-data-dir", ""}, []string{"storage.dataDir"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			if test.file != "" {
+				t.Setenv("CONFIG_FILE", writeConfig(t, test.file))
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			_, err := Load(test.args)
+			if err == nil {
+				t.Fatal("invalid configuration is loaded")
+			}
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q does not mention %s", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	path := writeConfig(t, "workpool:\n  workers: 2\n")
+	args := []string{"-config", path}
+	c, err := Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.CreateAsyncLogger()
+	for level := range logger.LogLevelStr {
+		log.SetSink(logger.LogLevel(level), ioutil.Discard)
+	}
+	store := NewStore(c, args, log)
+	var notified [][2]int
+	store.Subscribe(func(old *Config, new *Config) {
+		notified = append(notified, [2]int{old.WorkPool.Workers, new.WorkPool.Workers})
+	})
+
+	//invalid configuration is not applied
+	if err := ioutil.WriteFile(path, []byte("workpool:\n  workers: -1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil || store.Current() != c {
+		t.Fatalf("invalid configuration is applied: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("workpool:\n  workers: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	//reload without changes does not notify
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if store.Current().WorkPool.Workers != 3 || len(notified) != 1 || notified[0] != [2]int{2, 3} {
+		t.Fatalf("reload has %d workers and notified %v, expected 3 and a change from 2 to 3", store.Current().WorkPool.Workers, notified)
+	}
+}
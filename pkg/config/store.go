@@ -0,0 +1,172 @@
+package config
+
+import (
+	"../logger"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Subscriber is notified with the previous and the new configuration after a reload
+type Subscriber func(old *Config, new *Config)
+
+/*
+Store holds the current configuration and reloads it at runtime.
+Configuration is loaded again from the same sources (config file, environment, flags) when Reload is called,
+e.g. on SIGHUP, or when Watch notices that the config file has changed. Invalid configuration is rejected
+and the current one is kept.
+Components subscribe to the changes they can apply live, e.g. size of the work pool, log levels,
+rate limit and validation rules. Changes of other settings like listen address or storage backend
+are logged as requiring a restart.
+*/
+type Store struct {
+	mu          sync.RWMutex
+	current     *Config
+	subscribers []Subscriber
+
+	//reloads are serialized so that subscribers see changes in order
+	reload sync.Mutex
+	args   []string
+	log    *logger.AsyncLogger
+}
+
+//liveSettings are the settings applied without restart, other changes need a restart
+var liveSettings = []string{
+	"workpool.workers",
+	"workpool.autoscale",
+	"workpool.retry",
+	"workpool.jobTimeout",
+	"workpool.priority",
+	"log.level",
+	"log.components",
+	"log.format",
+	"server.rateLimit",
+	"server.adminKeys",
+	"validation",
+}
+
+//NewStore creates a store with the loaded configuration and the arguments it is loaded with
+func NewStore(c *Config, args []string, log *logger.AsyncLogger) *Store {
+	return &Store{
+		current: c,
+		args:    args,
+		log:     log.Component("config"),
+	}
+}
+
+//Current returns the current configuration, it must not be modified
+func (s *Store) Current() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+//Subscribe registers a function called after every successful reload
+func (s *Store) Subscribe(subscriber Subscriber) {
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, subscriber)
+	s.mu.Unlock()
+}
+
+//Reload loads the configuration again and notifies subscribers if it is valid
+func (s *Store) Reload() error {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	c, err := Load(s.args)
+	if err != nil {
+		s.log.Log(logger.ERROR, "Configuration is not reloaded: ", err.Error())
+		return err
+	}
+
+	s.mu.Lock()
+	old := s.current
+	s.current = c
+	subscribers := append([]Subscriber(nil), s.subscribers...)
+	s.mu.Unlock()
+
+	changed := changes(old, c)
+	if len(changed) == 0 {
+		return nil
+	}
+	var restart []string
+	for _, setting := range changed {
+		if !isLive(setting) {
+			restart = append(restart, setting)
+		}
+	}
+	s.log.Log(logger.WARNING, "Configuration has been reloaded, changed: ", strings.Join(changed, ", "))
+	if len(restart) > 0 {
+		s.log.Log(logger.WARNING, "Changes of ", strings.Join(restart, ", "), " need a restart")
+	}
+
+	for _, subscriber := range subscribers {
+		subscriber(old, c)
+	}
+	return nil
+}
+
+//Watch reloads the configuration whenever modification time of the config file changes until stop is closed.
+//It does nothing if configuration is not loaded from a file.
+func (s *Store) Watch(interval time.Duration, stop <-chan struct{}) {
+	path := s.Current().Path()
+	if path == "" {
+		return
+	}
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modified) {
+				continue
+			}
+			modified = info.ModTime()
+			s.Reload()
+		case <-stop:
+			return
+		}
+	}
+}
+
+//changes returns the names of settings which differ, e.g. "workpool.workers"
+func changes(old *Config, new *Config) []string {
+	var changed []string
+	o, n := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := 0; i < o.NumField(); i++ {
+		section := o.Type().Field(i)
+		tag := section.Tag.Get("yaml")
+		if tag == "" {
+			continue
+		}
+		if section.Name == "Validation" {
+			if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+				changed = append(changed, tag)
+			}
+			continue
+		}
+		for j := 0; j < o.Field(i).NumField(); j++ {
+			if !reflect.DeepEqual(o.Field(i).Field(j).Interface(), n.Field(i).Field(j).Interface()) {
+				changed = append(changed, tag+"."+section.Type.Field(j).Tag.Get("yaml"))
+			}
+		}
+	}
+	return changed
+}
+
+func isLive(setting string) bool {
+	for _, live := range liveSettings {
+		if setting == live {
+			return true
+		}
+	}
+	return false
+}
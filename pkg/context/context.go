@@ -4,6 +4,7 @@ Package context defines an application context that can be passed as parameter a
 package context
 
 import (
+	"../config"
 	"../logger"
 	"../memstore"
 )
@@ -11,8 +12,10 @@ import (
 //AppContext defines pointers to storage and logger which
 //all the packages use. Instead of passing all common attributes
 //separately across calls, better to define a context and pass
-//it around
+//it around. Config holds the current validated configuration of the application
+//and notifies components which can apply changes at runtime.
 type AppContext struct {
 	Storage memstore.Storage
 	Logger  *logger.AsyncLogger
+	Config  *config.Store
 }
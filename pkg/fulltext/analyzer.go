@@ -0,0 +1,156 @@
+package fulltext
+
+import (
+	"strings"
+	"unicode"
+)
+
+//token is an analyzed term and its position in the original text.
+//Positions count stop words as well so that phrase queries keep their gaps.
+type token struct {
+	term     string
+	position int
+}
+
+//stopWords are common english words which are not indexed
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true,
+	"not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "the": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true,
+}
+
+//analyze splits the text into lowercased and stemmed terms dropping stop words
+func analyze(text string) []token {
+	var tokens []token
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		tokens = append(tokens, token{term: stem(word), position: i})
+	}
+	return tokens
+}
+
+//stem reduces english words to a common root, e.g. "libraries" and "library" both become "librari".
+//It is a light version of the Porter stemmer covering plurals, -ed/-ing forms and common suffixes.
+func stem(word string) string {
+	if len(word) <= 3 || !isASCII(word) {
+		return word
+	}
+
+	//plurals
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		word = word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+
+	//past tense and gerunds
+	switch {
+	case strings.HasSuffix(word, "eed"):
+		if measure(word[:len(word)-3]) > 0 {
+			word = word[:len(word)-1]
+		}
+	case strings.HasSuffix(word, "ed") && hasVowel(word[:len(word)-2]):
+		word = restoreEnding(word[:len(word)-2])
+	case strings.HasSuffix(word, "ing") && hasVowel(word[:len(word)-3]):
+		word = restoreEnding(word[:len(word)-3])
+	}
+
+	if strings.HasSuffix(word, "y") && hasVowel(word[:len(word)-1]) {
+		word = word[:len(word)-1] + "i"
+	}
+
+	//common derivational suffixes
+	for _, rule := range suffixRules {
+		if strings.HasSuffix(word, rule[0]) {
+			base := word[:len(word)-len(rule[0])]
+			if measure(base) > 0 {
+				word = base + rule[1]
+			}
+			break
+		}
+	}
+	return word
+}
+
+var suffixRules = [][2]string{
+	{"ational", "ate"},
+	{"tional", "tion"},
+	{"ization", "ize"},
+	{"ation", "ate"},
+	{"fulness", "ful"},
+	{"ousness", "ous"},
+	{"iveness", "ive"},
+	{"alism", "al"},
+	{"aliti", "al"},
+	{"iviti", "ive"},
+	{"biliti", "ble"},
+	{"ness", ""},
+	{"ment", ""},
+	{"li", ""},
+}
+
+//restoreEnding fixes the stem after -ed or -ing is removed, e.g. "hopp" becomes "hop" and "creat" becomes "create"
+func restoreEnding(word string) string {
+	switch {
+	case strings.HasSuffix(word, "at"), strings.HasSuffix(word, "bl"), strings.HasSuffix(word, "iz"):
+		return word + "e"
+	case len(word) > 2 && word[len(word)-1] == word[len(word)-2] && !isVowel(word, len(word)-1) &&
+		!strings.ContainsAny(word[len(word)-1:], "lsz"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+//measure counts vowel-consonant sequences of the word as defined by Porter
+func measure(word string) int {
+	m := 0
+	prevVowel := false
+	for i := range word {
+		v := isVowel(word, i)
+		if prevVowel && !v {
+			m++
+		}
+		prevVowel = v
+	}
+	return m
+}
+
+func hasVowel(word string) bool {
+	for i := range word {
+		if isVowel(word, i) {
+			return true
+		}
+	}
+	return false
+}
+
+//isVowel reports whether the letter at i is a vowel, y is a vowel if it follows a consonant
+func isVowel(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return true
+	case 'y':
+		return i > 0 && !isVowel(word, i-1)
+	}
+	return false
+}
+
+func isASCII(word string) bool {
+	for i := 0; i < len(word); i++ {
+		if word[i] >= unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package fulltext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens []token
+	}{
+		//case and punctuation
+		{"Payment-Gateway, API!", []token{{"pay", 0}, {"gatewai", 1}, {"api", 2}}},
+		//stop words are dropped but keep their positions
+		{"the app for the payments", []token{{"app", 1}, {"pay", 4}}},
+		{"The And Of", nil},
+		//stemming
+		{"libraries library", []token{{"librari", 0}, {"librari", 1}}},
+		{"processes processing processed", []token{{"process", 0}, {"process", 1}, {"process", 2}}},
+		{"hopping hopped", []token{{"hop", 0}, {"hop", 1}}},
+		{"created creating", []token{{"create", 0}, {"create", 1}}},
+		{"agreed", []token{{"agree", 0}}},
+		{"relational conditional", []token{{"relate", 0}, {"condition", 1}}},
+		{"business status analysis", []token{{"busi", 0}, {"status", 1}, {"analysis", 2}}},
+		//short and non-ascii words are not stemmed
+		{"bus günlükler", []token{{"bus", 0}, {"günlükler", 1}}},
+	}
+	for _, test := range tests {
+		if tokens := analyze(test.text); !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("analyze(%q) = %v, expected %v", test.text, tokens, test.tokens)
+		}
+	}
+}
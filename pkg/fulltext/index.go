@@ -0,0 +1,215 @@
+//Package fulltext implements an inverted index for full-text search with BM25 ranking.
+//Text is tokenized, lowercased, stemmed and stop words are dropped before indexing.
+//Queries are terms and "quoted phrases". Documents matching any of the terms are returned,
+//if there are phrases then only the documents containing every phrase are returned.
+//Index is not safe for concurrent use, owner of the index is responsible for locking.
+package fulltext
+
+import (
+	"math"
+	"strings"
+)
+
+//BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+//document keeps the length of each field and the terms in it so that the document can be removed
+type document struct {
+	lengths map[string]int
+	terms   map[string][]string
+}
+
+//Index is an inverted index of documents made of named text fields.
+//Each field has its own postings and statistics, scores of fields are weighted by their boosts.
+type Index struct {
+	boosts   map[string]float64
+	docs     map[string]*document
+	postings map[string]map[string]map[string][]int //field -> term -> document -> positions
+	totalLen map[string]int
+}
+
+//NewIndex creates an index for the given fields and their boosts, e.g. title matches
+//can be made more relevant than description matches
+func NewIndex(boosts map[string]float64) *Index {
+	ix := &Index{
+		boosts:   boosts,
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]map[string][]int),
+		totalLen: make(map[string]int),
+	}
+	for field := range boosts {
+		ix.postings[field] = make(map[string]map[string][]int)
+	}
+	return ix
+}
+
+//Add indexes the document with the given id, replacing it if it is already indexed
+func (ix *Index) Add(id string, fields map[string]string) {
+	ix.Remove(id)
+
+	doc := &document{lengths: make(map[string]int), terms: make(map[string][]string)}
+	for field, text := range fields {
+		fieldPostings, ok := ix.postings[field]
+		if !ok {
+			continue
+		}
+		tokens := analyze(text)
+		for _, t := range tokens {
+			if fieldPostings[t.term] == nil {
+				fieldPostings[t.term] = make(map[string][]int)
+			}
+			if len(fieldPostings[t.term][id]) == 0 {
+				doc.terms[field] = append(doc.terms[field], t.term)
+			}
+			fieldPostings[t.term][id] = append(fieldPostings[t.term][id], t.position)
+		}
+		doc.lengths[field] = len(tokens)
+		ix.totalLen[field] += len(tokens)
+	}
+	ix.docs[id] = doc
+}
+
+//Remove drops the document with the given id from the index
+func (ix *Index) Remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for field, terms := range doc.terms {
+		for _, term := range terms {
+			delete(ix.postings[field][term], id)
+			if len(ix.postings[field][term]) == 0 {
+				delete(ix.postings[field], term)
+			}
+		}
+	}
+	for field, length := range doc.lengths {
+		ix.totalLen[field] -= length
+	}
+	delete(ix.docs, id)
+}
+
+//Search returns the ids of matching documents with their relevance scores.
+//Every term of the query, including the terms of phrases, adds its score to the document.
+func (ix *Index) Search(query string) map[string]float64 {
+	terms, phrases := parseQuery(query)
+
+	candidates := make(map[string]bool)
+	if len(phrases) > 0 {
+		//documents must contain every phrase
+		for id := range ix.phraseCandidates(phrases[0]) {
+			candidates[id] = true
+			for _, phrase := range phrases {
+				if !ix.hasPhrase(id, phrase) {
+					delete(candidates, id)
+					break
+				}
+			}
+		}
+		for _, phrase := range phrases {
+			for _, t := range phrase {
+				terms = append(terms, t.term)
+			}
+		}
+	} else {
+		//documents containing any of the terms
+		for _, term := range terms {
+			for field := range ix.boosts {
+				for id := range ix.postings[field][term] {
+					candidates[id] = true
+				}
+			}
+		}
+	}
+
+	scores := make(map[string]float64)
+	for id := range candidates {
+		for _, term := range terms {
+			for field := range ix.boosts {
+				if _, ok := ix.postings[field][term][id]; ok {
+					scores[id] += ix.score(field, term, id)
+				}
+			}
+		}
+	}
+	return scores
+}
+
+//score is the BM25 score of the term in a field of the document weighted by the field boost
+func (ix *Index) score(field string, term string, id string) float64 {
+	n := float64(len(ix.docs))
+	df := float64(len(ix.postings[field][term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	tf := float64(len(ix.postings[field][term][id]))
+	avgLen := float64(ix.totalLen[field]) / n
+	length := float64(ix.docs[id].lengths[field])
+	norm := 1.0
+	if avgLen > 0 {
+		norm = 1 - b + b*length/avgLen
+	}
+	return ix.boosts[field] * idf * tf * (k1 + 1) / (tf + k1*norm)
+}
+
+//phraseCandidates returns documents containing the first term of the phrase in any field
+func (ix *Index) phraseCandidates(phrase []token) map[string]bool {
+	candidates := make(map[string]bool)
+	for field := range ix.boosts {
+		for id := range ix.postings[field][phrase[0].term] {
+			candidates[id] = true
+		}
+	}
+	return candidates
+}
+
+//hasPhrase reports whether the terms of the phrase appear in a field of the document
+//at the same distances as in the phrase
+func (ix *Index) hasPhrase(id string, phrase []token) bool {
+	for field := range ix.boosts {
+		for _, start := range ix.postings[field][phrase[0].term][id] {
+			found := true
+			for _, t := range phrase[1:] {
+				if !containsInt(ix.postings[field][t.term][id], start+t.position-phrase[0].position) {
+					found = false
+					break
+				}
+			}
+			if found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//parseQuery splits the query into single terms and "quoted phrases"
+func parseQuery(query string) ([]string, [][]token) {
+	var terms []string
+	var phrases [][]token
+
+	parts := strings.Split(query, "\"")
+	for i, part := range parts {
+		tokens := analyze(part)
+		//odd parts are inside quotes, an unclosed quote is taken as plain terms
+		if i%2 == 1 && i < len(parts)-1 && len(tokens) > 1 {
+			phrases = append(phrases, tokens)
+			continue
+		}
+		for _, t := range tokens {
+			terms = append(terms, t.term)
+		}
+	}
+	return terms, phrases
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
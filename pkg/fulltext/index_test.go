@@ -0,0 +1,102 @@
+package fulltext
+
+import (
+	"sort"
+	"testing"
+)
+
+//testIndex indexes the documents by their ids, title is more relevant than description
+func testIndex(docs map[string][2]string) *Index {
+	ix := NewIndex(map[string]float64{"title": 2, "description": 1})
+	for id, doc := range docs {
+		ix.Add(id, map[string]string{"title": doc[0], "description": doc[1]})
+	}
+	return ix
+}
+
+//ranked returns the ids of the search result ordered by score, highest first
+func ranked(scores map[string]float64) []string {
+	var ids []string
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return scores[ids[i]] > scores[ids[j]] })
+	return ids
+}
+
+func TestPhraseQuery(t *testing.T) {
+	ix := testIndex(map[string][2]string{
+		"exact":    {"Payment Gateway", "Accepts cards"},
+		"inflect":  {"Mobile app", "Payments and payment gateways"},
+		"stopword": {"Gateway of payment", "Routes the payment to the gateway"},
+		"apart":    {"Payment", "A gateway to banks"},
+		"reversed": {"Gateway payment", ""},
+	})
+	tests := []struct {
+		query   string
+		matches []string
+	}{
+		{`"payment gateway"`, []string{"exact", "inflect"}},
+		//stop words are not indexed but keep their gaps, so the terms must be one word apart
+		{`"gateway of payment"`, []string{"stopword"}},
+		{`"gateway to payment"`, []string{"stopword"}},
+		{`"gateway payment"`, []string{"reversed"}},
+		{`"payment to gateway"`, nil},
+		{`"payment gateway" "accepts cards"`, []string{"exact"}},
+		{`"payment gateway" mobile`, []string{"exact", "inflect"}},
+		//an unclosed quote is taken as plain terms
+		{`"reversed gateway`, []string{"exact", "inflect", "stopword", "apart", "reversed"}},
+	}
+	for _, test := range tests {
+		scores := ix.Search(test.query)
+		matches := ranked(scores)
+		sort.Strings(matches)
+		sort.Strings(test.matches)
+		if len(matches) != len(test.matches) {
+			t.Errorf("%s matches %v, expected %v", test.query, matches, test.matches)
+			continue
+		}
+		for i := range matches {
+			if matches[i] != test.matches[i] {
+				t.Errorf("%s matches %v, expected %v", test.query, matches, test.matches)
+				break
+			}
+		}
+	}
+}
+
+func TestBM25Ranking(t *testing.T) {
+	ix := testIndex(map[string][2]string{
+		"title":    {"Invoice manager", "Keeps records of a company"},
+		"repeated": {"Ledger", "Invoice import, invoice export and invoice archive"},
+		"once":     {"Ledger", "Invoice import"},
+		"long":     {"Ledger", "Invoice import of a very long description full of other words about books"},
+		"none":     {"Calendar", "Meetings and events"},
+	})
+	expected := []string{"title", "repeated", "once", "long"}
+	scores := ix.Search("invoices")
+	if got := ranked(scores); len(got) != len(expected) {
+		t.Fatalf("invoices ranks %v, expected %v", got, expected)
+	} else {
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("invoices ranks %v, expected %v", got, expected)
+			}
+		}
+	}
+
+	//a rare term is more relevant than a common one
+	scores = ix.Search("ledger calendar")
+	if scores["none"] <= scores["once"] {
+		t.Fatalf("rare term scores %f, common term %f", scores["none"], scores["once"])
+	}
+
+	//removed documents are not found and do not count in the statistics
+	ix.Remove("title")
+	if _, ok := ix.Search("invoice")["title"]; ok {
+		t.Fatal("removed document is found")
+	}
+	if ix.totalLen["title"] != 4 {
+		t.Fatalf("title length of the index is %d after remove, expected 4", ix.totalLen["title"])
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Package lifecycle runs the orderly shutdown of the application.
+Components register their stop functions in the order they must be stopped, e.g. HTTP server first so that
+no new request comes in, then the work pool, then storage. Shutdown starts on SIGINT/SIGTERM or when
+a FATAL message is logged. Stop functions share a single deadline and the logger is closed last,
+after every other component has logged its shutdown.
+
+	manager := lifecycle.NewManager(asyncLogger, 30*time.Second)
+	manager.OnStop("http server", httpServer.Shutdown)
+	manager.OnStop("storage", func(ctx context.Context) error { return storage.Close() })
+	os.Exit(manager.Wait())
+*/
+package lifecycle
+
+import (
+	"../logger"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//LoggerTimeout bounds the time to flush the logs after other components are stopped
+const LoggerTimeout = 5 * time.Second
+
+//StopFunc stops a component, it should return when the component is stopped or ctx is done
+type StopFunc func(ctx context.Context) error
+
+//component is a stop function with the name used in logs
+type component struct {
+	name string
+	stop StopFunc
+}
+
+//Manager stops registered components in order when the application is asked to shut down
+type Manager struct {
+	log        *logger.AsyncLogger
+	timeout    time.Duration
+	components []component
+}
+
+//NewManager creates a manager which gives components the given time to stop
+func NewManager(log *logger.AsyncLogger, timeout time.Duration) *Manager {
+	return &Manager{
+		log:     log.Component("lifecycle"),
+		timeout: timeout,
+	}
+}
+
+//OnStop registers the stop function of a component. Components are stopped in registration order.
+func (m *Manager) OnStop(name string, stop StopFunc) {
+	m.components = append(m.components, component{name: name, stop: stop})
+}
+
+//Wait blocks until SIGINT/SIGTERM is received or a FATAL message is logged, then stops the components
+//and the logger. Returns the exit code of the process: 0 if shutdown is requested by a signal and every
+//component is stopped in time, 1 otherwise. A second signal exits immediately.
+func (m *Manager) Wait() int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	code := 0
+	select {
+	case sig := <-signals:
+		m.log.Log(logger.WARNING, "Signal ", sig.String(), " received, shutting down...")
+	case <-m.log.Shutdown():
+		m.log.Log(logger.WARNING, "Fatal error, shutting down...")
+		code = 1
+	}
+
+	go func() {
+		sig := <-signals
+		m.log.Log(logger.WARNING, "Signal ", sig.String(), " received again, exiting without shutdown")
+		os.Exit(1)
+	}()
+
+	if !m.stop() {
+		code = 1
+	}
+	return code
+}
+
+//stop stops the components in order and then the logger, reports whether everything stopped in time
+func (m *Manager) stop() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	ok := true
+	for _, c := range m.components {
+		start := time.Now()
+		if err := c.stop(ctx); err != nil {
+			m.log.Log(logger.ERROR, "Stopping ", c.name, " failed: ", err.Error())
+			ok = false
+			continue
+		}
+		m.log.Log(logger.INFO, "Stopped ", c.name, " in ", time.Since(start).String())
+	}
+	m.log.Log(logger.WARNING, "Shutdown completed")
+
+	//logger is stopped last so that shutdown of every component is logged
+	logCtx, logCancel := context.WithTimeout(context.Background(), LoggerTimeout)
+	defer logCancel()
+	if err := m.log.Close(logCtx); err != nil {
+		os.Stderr.WriteString("Logs cannot be flushed: " + err.Error() + "\n")
+		ok = false
+	}
+	return ok
+}
@@ -1,13 +1,40 @@
 /*
-Package logger defines a async logger using channels.
+Package logger defines a async logger using a bounded ring buffer.
 Three different log level has been defined which are INFO,WARNING ,ERROR and FATAL
-INFO and WARNING logs are sent to Stdout and ERROR FATAL logs to StdErr by default
+INFO and WARNING logs are sent to Stdout and ERROR FATAL logs to StdErr by default, SetSink changes the sink of a level.
+Messages below the minimum level are discarded, each component (memstore, workpool, server)
+can have its own level and levels can be changed at runtime.
+
+Log calls append the message to the buffer and return, a single goroutine writes the messages in order.
+If the buffer is full, the overflow policy decides whether the caller waits (Block) or a message is dropped
+(DropOldest, DropNewest). Flush waits until logged messages are written and Close drains the buffer
+before the logger stops.
+
+	asyncLogger := logger.CreateAsyncLoggerWithOptions(logger.Options{BufferSize: 4096, Overflow: logger.DropOldest})
+	defer asyncLogger.Close(ctx)
+
+FATAL messages do not terminate the process. As soon as a FATAL message is logged, Shutdown channel is closed
+and the owner of the logger is expected to shut the application down in order and exit.
+FATAL messages are never dropped: they wait for room in the buffer whatever the overflow policy is,
+and they are written at once by the caller if the logger is already closed.
+
+	<-asyncLogger.Shutdown()
+
+Besides plain messages, log messages can have structured key/value fields.
+Messages are encoded by a pluggable Encoder, e.g. JSON lines or logfmt.
+
+	asyncLogger.SetEncoder(logger.JSONEncoder{})
+	asyncLogger.LogFields(logger.ERROR, "insert failed", logger.F("key", key), logger.Err(err))
+	requestLogger := asyncLogger.With(logger.RequestID(id))
 */
 package logger
 
 import (
-	"log"
-	"os"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type LogLevel uint8
@@ -34,101 +61,191 @@ func (level LogLevel) string() string {
 	return LogLevelStr[level]
 }
 
+//DefaultBufferSize is the number of messages buffered by CreateAsyncLogger
+const DefaultBufferSize = 1024
+
+//Options configures the buffer of the logger
+type Options struct {
+	//BufferSize is the capacity of the ring buffer, DefaultBufferSize if not set
+	BufferSize int
+	//Overflow is the policy applied when the buffer is full
+	Overflow OverflowPolicy
+}
+
 /*
-AsyncLogger objects defines logger and channel for each log level.
+AsyncLogger objects defines sink for each log level and the buffer messages are passed through.
 Since loggers work in async manner, it is possible to stop logger as well.
-for that purpose, stop channel has been defined. when logger reads a value
-from stop channel, it returns.
+Close stops accepting messages and waits until buffered ones are written.
+stopped channel is closed when the writing goroutine returns.
+Loggers created by With share buffer, encoder and routing with their parent
+and add their fields to every message.
 */
 type AsyncLogger struct {
-	info           *log.Logger
-	warning        *log.Logger
-	error          *log.Logger
-	fatal          *log.Logger
-	infoLogChan    chan AsyncLogMsg
-	warningLogChan chan AsyncLogMsg
-	errorLogChan   chan AsyncLogMsg
-	fatalLogChan   chan AsyncLogMsg
+	//routing holds sinks and levels
+	routing   *routing
+	component string
 
-	//stop signal
-	stop chan bool
+	pipeline *pipeline
+	stopped  chan struct{}
+
+	//shutdown is closed once when the first FATAL message is logged
+	shutdown     chan struct{}
+	shutdownOnce *sync.Once
+
+	//encoder is shared with the loggers created by With
+	encoder *atomic.Value
+	fields  []Field
 }
 
 /*
 Defines the structure of log messages.
 level is a constant to identify log level
 and logMsg is the actual log message.
+fields are the structured key/value pairs of the message.
 */
 type AsyncLogMsg struct {
 	level  LogLevel
 	logMsg []string
+	fields []Field
+	time   time.Time
 }
 
-//CreateAsyncLogger creates a async logger instance and initialize the channels.
-//The purpose for using different channels for different levels is to provide flexibility
-//so that we can implement different logic for different levels if needed.
-//channels also start being listened as soon as we create logger instance.
+//CreateAsyncLogger creates a async logger instance with a buffer of DefaultBufferSize messages
+//which blocks callers when it is full.
 func CreateAsyncLogger() *AsyncLogger {
+	return CreateAsyncLoggerWithOptions(Options{})
+}
+
+//CreateAsyncLoggerWithOptions creates a async logger instance with the given buffer size and overflow policy.
+//buffer also starts being listened as soon as we create logger instance.
+func CreateAsyncLoggerWithOptions(opts Options) *AsyncLogger {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
 
 	var asyncLogger = AsyncLogger{
-		info:           log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
-		warning:        log.New(os.Stdout, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile),
-		error:          log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
-		fatal:          log.New(os.Stderr, "FATAL: ", log.Ldate|log.Ltime|log.Lshortfile),
-		infoLogChan:    make(chan AsyncLogMsg),
-		warningLogChan: make(chan AsyncLogMsg),
-		errorLogChan:   make(chan AsyncLogMsg),
-		fatalLogChan:   make(chan AsyncLogMsg),
-		stop:           make(chan bool),
+		routing:      newRouting(),
+		pipeline:     newPipeline(opts.BufferSize, opts.Overflow),
+		stopped:      make(chan struct{}),
+		shutdown:     make(chan struct{}),
+		shutdownOnce: &sync.Once{},
+		encoder:      &atomic.Value{},
 	}
+	asyncLogger.SetEncoder(TextEncoder{})
 	asyncLogger.startLogger()
 	return &asyncLogger
 }
 
-//startLogger initiates a go route that waits receiving data from logger channels.
+//SetEncoder changes how messages are written, e.g. JSONEncoder or LogfmtEncoder.
+//It applies to the parent logger and all loggers created by With.
+func (l *AsyncLogger) SetEncoder(encoder Encoder) {
+	l.encoder.Store(&encoder)
+}
+
+//With returns a logger which adds the given fields to every message it logs.
+//Returned logger shares the buffer of this logger so messages are still written in the same pipeline.
+func (l *AsyncLogger) With(fields ...Field) *AsyncLogger {
+	child := *l
+	child.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &child
+}
+
+//startLogger initiates a go route that waits receiving data from logger buffer.
 func (l *AsyncLogger) startLogger() {
 	go l.listen()
 }
 
-//listen initiates a infinite loop to listen log channels until stop message received.
+//listen initiates a loop writing buffered messages in order until logger is closed and buffer is drained.
 func (l *AsyncLogger) listen() {
+	defer close(l.stopped)
 	for {
-		select {
-		case logMsg := <-l.infoLogChan:
-			l.info.Println(logMsg.level.string(), " : ", logMsg.logMsg)
-		case logMsg := <-l.warningLogChan:
-			l.info.Println(logMsg.level.string(), " : ", logMsg.logMsg)
-		case logMsg := <-l.errorLogChan:
-			l.info.Println(logMsg.level.string(), " : ", logMsg.logMsg)
-		case logMsg := <-l.fatalLogChan:
-			l.fatal.Println(logMsg.level.string(), " : ", logMsg.logMsg)
-			os.Exit(1)
-		case <-l.stop:
+		logMsg, ok := l.pipeline.take()
+		if !ok {
 			return
 		}
+		l.routing.write(logMsg.level, l.encode(logMsg))
+		l.pipeline.done()
 	}
 }
 
-//Log function performs actual logging by passing log message into related channel.
+//encode converts the message into a line using the current encoder.
+//Parts of the message are concatenated as they are, callers already put spaces between them.
+func (l *AsyncLogger) encode(logMsg AsyncLogMsg) []byte {
+	encoder := *l.encoder.Load().(*Encoder)
+	line := encoder.Encode(Entry{
+		Time:    logMsg.time,
+		Level:   logMsg.level,
+		Message: strings.Join(logMsg.logMsg, ""),
+		Fields:  logMsg.fields,
+	})
+	return append(line, '\n')
+}
+
+//Log function performs actual logging by passing log message into the buffer.
 //Gets log level and log message as arguments.
 func (l *AsyncLogger) Log(level LogLevel, msg ...string) {
-	switch level {
-	case INFO:
-		go func() { l.infoLogChan <- AsyncLogMsg{level: level, logMsg: msg} }()
+	if !l.Enabled(level) {
+		return
+	}
+	l.put(AsyncLogMsg{level: level, logMsg: msg, fields: l.fields, time: time.Now()})
+}
 
-	case WARNING:
-		go func() { l.warningLogChan <- AsyncLogMsg{level: level, logMsg: msg} }()
+//LogFields logs the message with structured key/value fields in addition to the fields of the logger.
+func (l *AsyncLogger) LogFields(level LogLevel, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := append(append([]Field(nil), l.fields...), fields...)
+	l.put(AsyncLogMsg{level: level, logMsg: []string{msg}, fields: all, time: time.Now()})
+}
 
-	case ERROR:
-		go func() { l.errorLogChan <- AsyncLogMsg{level: level, logMsg: msg} }()
+//put passes the message into the buffer. A FATAL message signals shutdown before it is buffered,
+//so that shutdown does not depend on the message being written, and it is written at once if the logger is closed.
+func (l *AsyncLogger) put(logMsg AsyncLogMsg) {
+	if logMsg.level == FATAL {
+		l.shutdownOnce.Do(func() { close(l.shutdown) })
+	}
+	if !l.pipeline.put(logMsg) && logMsg.level == FATAL {
+		l.routing.write(logMsg.level, l.encode(logMsg))
+	}
+}
+
+//Shutdown returns a channel which is closed when a FATAL message is logged.
+//Application should stop accepting work, drain and flush its components and exit with a non-zero code.
+func (l *AsyncLogger) Shutdown() <-chan struct{} {
+	return l.shutdown
+}
+
+//Dropped returns the number of messages dropped due to the overflow policy or logged after Close
+func (l *AsyncLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.pipeline.dropped)
+}
 
-	case FATAL:
-		go func() { l.fatalLogChan <- AsyncLogMsg{level: level, logMsg: msg} }()
+//Flush waits until all messages logged before the call are written.
+//Returns ctx error if ctx is done before.
+func (l *AsyncLogger) Flush(ctx context.Context) error {
+	return l.pipeline.flush(ctx)
+}
 
+//Close stops accepting messages and waits until buffered messages are written, then closes file sinks.
+//Returns ctx error if ctx is done before, remaining messages are still written in the background.
+func (l *AsyncLogger) Close(ctx context.Context) error {
+	if !l.pipeline.close() {
+		return ErrClosed
+	}
+	select {
+	case <-l.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, reopener := range l.routing.reopeners() {
+		reopener.Close()
 	}
+	return nil
 }
 
 //Stop function is responsible for ending logging loop.
+//It drains the buffer before it returns, same as Close without deadline.
 func (l *AsyncLogger) Stop() {
-	go func() { l.stop <- true }()
+	l.Close(context.Background())
 }
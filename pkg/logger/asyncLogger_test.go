@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//blockingSink keeps writes waiting until it is released so that the buffer of the logger fills up
+type blockingSink struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (s *blockingSink) Write(p []byte) (int, error) {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *blockingSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestFatalWithSaturatedBuffer(t *testing.T) {
+	for _, policy := range []OverflowPolicy{Block, DropOldest, DropNewest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			sink := &blockingSink{release: make(chan struct{})}
+			l := CreateAsyncLoggerWithOptions(Options{BufferSize: 1, Overflow: policy})
+			for level := range LogLevelStr {
+				l.SetSink(LogLevel(level), sink)
+			}
+
+			//first message is being written and blocks the writer, second one fills the buffer
+			l.Log(INFO, "first")
+			time.Sleep(10 * time.Millisecond)
+			l.Log(INFO, "second")
+
+			logged := make(chan struct{})
+			go func() {
+				l.Log(FATAL, "fatal")
+				close(logged)
+			}()
+			select {
+			case <-l.Shutdown():
+			case <-time.After(time.Second):
+				t.Fatal("shutdown is not signalled while the buffer is full")
+			}
+
+			close(sink.release)
+			select {
+			case <-logged:
+			case <-time.After(time.Second):
+				t.Fatal("FATAL message is not accepted after the buffer is drained")
+			}
+			if err := l.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(sink.String(), "fatal") {
+				t.Fatalf("FATAL message is not written: %q", sink.String())
+			}
+		})
+	}
+}
+
+func TestFatalAfterClose(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	close(sink.release)
+	l := CreateAsyncLoggerWithOptions(Options{BufferSize: 1, Overflow: DropNewest})
+	l.SetSink(FATAL, sink)
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Log(FATAL, "fatal after close")
+	select {
+	case <-l.Shutdown():
+	default:
+		t.Fatal("shutdown is not signalled")
+	}
+	if !strings.Contains(sink.String(), "fatal after close") {
+		t.Fatalf("FATAL message is not written: %q", sink.String())
+	}
+	if l.Dropped() != 0 {
+		t.Fatalf("FATAL message is counted as dropped: %d", l.Dropped())
+	}
+}
+
+func TestBufferedFatalIsNotDropped(t *testing.T) {
+	for _, size := range []int{1, 3} {
+		sink := &blockingSink{release: make(chan struct{})}
+		l := CreateAsyncLoggerWithOptions(Options{BufferSize: size, Overflow: DropOldest})
+		for level := range LogLevelStr {
+			l.SetSink(LogLevel(level), sink)
+		}
+
+		//first message blocks the writer, FATAL waits in the buffer and INFO messages flood it
+		l.Log(INFO, "first")
+		time.Sleep(10 * time.Millisecond)
+		l.Log(FATAL, "fatal")
+		flooded := make(chan struct{})
+		go func() {
+			for i := 0; i < 100; i++ {
+				l.Log(INFO, "flood")
+			}
+			close(flooded)
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		close(sink.release)
+		<-flooded
+		if err := l.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sink.String(), "fatal") {
+			t.Fatalf("buffer of %d: FATAL message is dropped by later messages", size)
+		}
+		if size > 1 && l.Dropped() == 0 {
+			t.Fatalf("buffer of %d: no INFO message is dropped", size)
+		}
+	}
+}
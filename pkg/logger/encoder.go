@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Encoder converts a log entry into a single line of output (without the trailing newline).
+//Encoders are pluggable so that output can be parsed by log pipelines.
+type Encoder interface {
+	Encode(entry Entry) []byte
+}
+
+//ParseEncoder returns the encoder with the given name: text, json or logfmt
+func ParseEncoder(name string) (Encoder, error) {
+	switch name {
+	case "text":
+		return TextEncoder{}, nil
+	case "json":
+		return JSONEncoder{}, nil
+	case "logfmt":
+		return LogfmtEncoder{}, nil
+	}
+	return nil, errors.New("unknown log format " + name)
+}
+
+//TextEncoder is the default human readable encoder:
+//2006/01/02 15:04:05 INFO: message key=value
+type TextEncoder struct{}
+
+//Encode implements Encoder
+func (TextEncoder) Encode(entry Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString(entry.Time.Format("2006/01/02 15:04:05 "))
+	b.WriteString(entry.Level.string())
+	b.WriteString(": ")
+	b.WriteString(entry.Message)
+	for _, field := range entry.Fields {
+		b.WriteByte(' ')
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(fieldString(field.Value)))
+	}
+	return b.Bytes()
+}
+
+//JSONEncoder writes every entry as a JSON object on its own line (JSON lines):
+//{"time":"...","level":"INFO","msg":"message","key":"value"}
+type JSONEncoder struct{}
+
+//Encode implements Encoder
+func (JSONEncoder) Encode(entry Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, entry.Time.Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, entry.Level.string())
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, entry.Message)
+	for _, field := range entry.Fields {
+		b.WriteByte(',')
+		writeJSON(&b, field.Key)
+		b.WriteByte(':')
+		switch value := field.Value.(type) {
+		case error:
+			writeJSON(&b, value.Error())
+		case fmt.Stringer:
+			writeJSON(&b, value.String())
+		default:
+			if encoded, err := json.Marshal(value); err == nil {
+				b.Write(encoded)
+			} else {
+				writeJSON(&b, fmt.Sprint(value))
+			}
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+//LogfmtEncoder writes every entry as logfmt key/value pairs:
+//time=... level=INFO msg="message text" key=value
+type LogfmtEncoder struct{}
+
+//Encode implements Encoder
+func (LogfmtEncoder) Encode(entry Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString("time=")
+	b.WriteString(entry.Time.Format(time.RFC3339Nano))
+	b.WriteString(" level=")
+	b.WriteString(entry.Level.string())
+	b.WriteString(" msg=")
+	b.WriteString(logfmtValue(entry.Message))
+	for _, field := range entry.Fields {
+		b.WriteByte(' ')
+		b.WriteString(field.Key)
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(fieldString(field.Value)))
+	}
+	return b.Bytes()
+}
+
+//fieldString converts a field value to string for text based encoders
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+//logfmtValue quotes the value if it contains spaces, quotes or equal signs
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//writeJSON writes a JSON string without escaping html characters
+func writeJSON(b *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	//Encode appends a newline
+	b.Truncate(b.Len() - 1)
+}
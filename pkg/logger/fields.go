@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"time"
+)
+
+//Field is a key/value pair attached to a log message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//F creates a field with the given key and value
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//Err creates an "error" field from the given error
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+//RequestID creates a "request_id" field so that all messages of a request can be correlated
+func RequestID(id string) Field {
+	return Field{Key: "request_id", Value: id}
+}
+
+//Entry is a log message as it is handed to an Encoder
+type Entry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Fields  []Field
+}
@@ -0,0 +1,315 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//backupTimeFormat is the timestamp added to the name of rotated files, e.g. app-2006-01-02T15-04-05.000.log.
+//Files rotated in the same millisecond have a counter after the timestamp, e.g. app-2006-01-02T15-04-05.000-1.log
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+//FileSinkOptions defines when a log file is rotated and how long rotated files are kept.
+//Zero values disable the related rotation or retention rule.
+type FileSinkOptions struct {
+	//MaxSize rotates the file before it grows beyond MaxSize bytes
+	MaxSize int64
+	//Daily rotates the file when the first message of a new day is written
+	Daily bool
+	//Compress gzips rotated files
+	Compress bool
+	//MaxBackups is the number of rotated files kept
+	MaxBackups int
+	//MaxAge is how long rotated files are kept
+	MaxAge time.Duration
+}
+
+//Reopener is implemented by sinks writing to files.
+//Reopen opens the file again after it is moved by an external tool such as logrotate.
+type Reopener interface {
+	Reopen() error
+	Close() error
+}
+
+/*
+FileSink is a sink writing to a file with size and daily rotation.
+Rotated files are renamed with a timestamp next to the log file, e.g. app.log becomes app-2006-01-02T15-04-05.000.log,
+then compressed and old ones removed in background so that the logger is not blocked.
+FileSink is safe for concurrent use, so the same sink can be set for several levels.
+*/
+type FileSink struct {
+	mu     sync.Mutex
+	path   string
+	opts   FileSinkOptions
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+
+	//mill compresses and removes rotated files in background
+	mill     chan struct{}
+	millDone sync.WaitGroup
+}
+
+//NewFileSink opens or creates the log file at path and appends to it.
+//Rotated files left by previous runs are compressed and removed by the retention rules at once.
+func NewFileSink(path string, opts FileSinkOptions) (*FileSink, error) {
+	sink := &FileSink{
+		path: path,
+		opts: opts,
+		mill: make(chan struct{}, 1),
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	sink.millDone.Add(1)
+	go sink.runMill()
+	sink.mill <- struct{}{}
+	return sink, nil
+}
+
+//Write writes the line to the file, rotating the file first if needed
+func (s *FileSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if s.file == nil {
+		//previous reopen or rotation failed, try again
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	if s.shouldRotate(int64(len(p)), time.Now()) {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	return n, err
+}
+
+//Reopen closes and opens the log file again, so that writes go to a new file
+//if the old one has been moved away. It is called on SIGHUP.
+func (s *FileSink) Reopen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return os.ErrClosed
+	}
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	return s.open()
+}
+
+//Close closes the log file and waits until rotated files are compressed
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return os.ErrClosed
+	}
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	s.closed = true
+	close(s.mill)
+	s.mu.Unlock()
+	s.millDone.Wait()
+	return err
+}
+
+//open opens the log file, time of the last write is taken from the existing file for daily rotation
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.opened = time.Now()
+	if s.size > 0 {
+		s.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (s *FileSink) shouldRotate(n int64, now time.Time) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.opts.MaxSize > 0 && s.size+n > s.opts.MaxSize {
+		return true
+	}
+	y1, m1, d1 := s.opened.Date()
+	y2, m2, d2 := now.Date()
+	return s.opts.Daily && (y1 != y2 || m1 != m2 || d1 != d2)
+}
+
+//rotate renames the current file with a timestamp and opens a new one, s.mu must be held
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, s.backupName(time.Now())); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	select {
+	case s.mill <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//backupName is a free name for the file rotated at the given time, so that rename does not overwrite
+//a backup rotated in the same millisecond. Compressed backups are taken into account as well.
+func (s *FileSink) backupName(t time.Time) string {
+	ext := filepath.Ext(s.path)
+	stamp := strings.TrimSuffix(s.path, ext) + "-" + t.Format(backupTimeFormat)
+	name := stamp + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = stamp + "-" + strconv.Itoa(i) + ext
+	}
+	return name
+}
+
+//exists reports whether there is a file at path, a file which cannot be checked is taken as existing
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+func (s *FileSink) runMill() {
+	defer s.millDone.Done()
+	for range s.mill {
+		s.millRotated()
+	}
+}
+
+//backup is a rotated file and the time it has been rotated at,
+//seq orders the files rotated in the same millisecond
+type backup struct {
+	path string
+	time time.Time
+	seq  int
+}
+
+//millRotated compresses rotated files and removes the ones exceeding MaxBackups or MaxAge
+func (s *FileSink) millRotated() {
+	backups := s.backups()
+
+	//newest first
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].seq > backups[j].seq
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+
+	var keep []backup
+	for i, b := range backups {
+		if (s.opts.MaxBackups > 0 && i >= s.opts.MaxBackups) || (s.opts.MaxAge > 0 && time.Since(b.time) > s.opts.MaxAge) {
+			os.Remove(b.path)
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if !s.opts.Compress {
+		return
+	}
+	for _, b := range keep {
+		if !strings.HasSuffix(b.path, ".gz") {
+			if err := compressFile(b.path); err == nil {
+				os.Remove(b.path)
+			}
+		}
+	}
+}
+
+//backups lists the rotated files of the log file
+func (s *FileSink) backups() []backup {
+	ext := filepath.Ext(s.path)
+	prefix := filepath.Base(strings.TrimSuffix(s.path, ext)) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(s.path))
+	if err != nil {
+		return nil
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if entry.IsDir() || !strings.HasPrefix(stamp, prefix) {
+			continue
+		}
+		stamp = strings.TrimPrefix(stamp, prefix)
+		seq := 0
+		if len(stamp) > len(backupTimeFormat) && stamp[len(backupTimeFormat)] == '-' {
+			if seq, err = strconv.Atoi(stamp[len(backupTimeFormat)+1:]); err != nil {
+				continue
+			}
+			stamp = stamp[:len(backupTimeFormat)]
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(filepath.Dir(s.path), name), time: t, seq: seq})
+	}
+	return backups
+}
+
+//compressFile writes the gzipped copy of the file next to it
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	return dst.Close()
+}
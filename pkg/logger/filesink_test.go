@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//logFiles returns the names of the files in dir and their contents, gzipped files are decompressed
+func logFiles(t *testing.T, dir string) (names []string, contents string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var data []byte
+		if strings.HasSuffix(entry.Name(), ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err = ioutil.ReadAll(gz)
+		} else {
+			data, err = ioutil.ReadAll(f)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents += string(data)
+	}
+	sort.Strings(names)
+	return names, contents
+}
+
+//writeLines writes the lines to a sink with the given options and closes it
+func writeLines(t *testing.T, path string, opts FileSinkOptions, lines ...string) {
+	sink, err := NewFileSink(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if _, err := sink.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"first\n", "second\n", "third\n", "fourth\n"}
+	//every line is larger than the room left, so each one rotates the file, most likely in the same millisecond
+	writeLines(t, filepath.Join(dir, "app.log"), FileSinkOptions{MaxSize: 10}, lines...)
+
+	names, contents := logFiles(t, dir)
+	if len(names) != len(lines) {
+		t.Fatalf("files %v, expected the log file and %d backups", names, len(lines)-1)
+	}
+	for _, line := range lines {
+		if !strings.Contains(contents, line) {
+			t.Fatalf("%q is lost in rotation, files have %q", line, contents)
+		}
+	}
+	current, err := ioutil.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil || string(current) != "fourth\n" {
+		t.Fatalf("log file has %q, expected the last line", current)
+	}
+}
+
+func TestFileSinkBackupName(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileSink{path: filepath.Join(dir, "app.log")}
+	now := time.Now()
+	stamp := filepath.Join(dir, "app-"+now.Format(backupTimeFormat))
+
+	//a compressed backup of the same millisecond takes the name as well
+	for _, name := range []string{stamp + ".log", stamp + "-1.log.gz"} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name := s.backupName(now); name != stamp+"-2.log" {
+		t.Fatalf("backup name is %s, expected %s", name, stamp+"-2.log")
+	}
+
+	//counter orders backups of the same millisecond
+	backups := s.backups()
+	sort.Slice(backups, func(i, j int) bool { return backups[i].seq < backups[j].seq })
+	if len(backups) != 2 || backups[0].seq != 0 || backups[1].seq != 1 || !backups[1].time.Equal(backups[0].time) {
+		t.Fatalf("backups are listed as %+v", backups)
+	}
+}
+
+func TestFileSinkCompression(t *testing.T) {
+	dir := t.TempDir()
+	writeLines(t, filepath.Join(dir, "app.log"), FileSinkOptions{MaxSize: 10, Compress: true}, "first\n", "second\n")
+
+	//Close waits for the compression
+	names, contents := logFiles(t, dir)
+	if len(names) != 2 || !strings.HasSuffix(names[0], ".log.gz") {
+		t.Fatalf("files %v, expected the log file and a compressed backup", names)
+	}
+	if contents != "first\nsecond\n" {
+		t.Fatalf("files have %q", contents)
+	}
+}
+
+func TestFileSinkRetentionAtStart(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name string
+		opts FileSinkOptions
+		kept []string
+	}{
+		{"max backups", FileSinkOptions{MaxBackups: 2}, []string{"1h", "2h"}},
+		{"max age", FileSinkOptions{MaxAge: 90 * time.Minute}, []string{"1h"}},
+		{"compress", FileSinkOptions{Compress: true}, []string{"1h", "2h", "3h"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backups := map[string]string{}
+			for i, age := range []string{"1h", "2h", "3h"} {
+				name := "app-" + now.Add(-time.Duration(i+1)*time.Hour).Format(backupTimeFormat) + ".log"
+				backups[age] = name
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(age+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			//rotated files of a previous run are handled without waiting for a rotation
+			writeLines(t, filepath.Join(dir, "app.log"), test.opts)
+
+			expected := []string{"app.log"}
+			for _, age := range test.kept {
+				name := backups[age]
+				if test.opts.Compress {
+					name += ".gz"
+				}
+				expected = append(expected, name)
+			}
+			sort.Strings(expected)
+			if names, _ := logFiles(t, dir); strings.Join(names, " ") != strings.Join(expected, " ") {
+				t.Fatalf("files %v, expected %v", names, expected)
+			}
+		})
+	}
+}
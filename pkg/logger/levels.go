@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+//ErrUnknownLevel is returned when a level name is not one of LogLevelStr
+var ErrUnknownLevel = errors.New("unknown log level")
+
+//ParseLevel returns the level with the given name, case-insensitive
+func ParseLevel(name string) (LogLevel, error) {
+	for i, s := range LogLevelStr {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return INFO, ErrUnknownLevel
+}
+
+//Levels is the minimum level of the logger and the overridden levels of components
+type Levels struct {
+	Level      string            `json:"level" yaml:"level"`
+	Components map[string]string `json:"components" yaml:"components"`
+}
+
+/*
+routing decides which messages are written and where.
+Messages below the minimum level of their component (or the logger if the component has no level)
+are discarded before they are buffered. FATAL messages are never discarded.
+Each level has its own sink. It is shared by the logger and loggers created by With and Component
+so that levels can be changed at runtime.
+*/
+type routing struct {
+	mu         sync.RWMutex
+	min        LogLevel
+	components map[string]LogLevel
+	sinks      [len(LogLevelStr)]io.Writer
+}
+
+//newRouting creates the default routing: INFO and WARNING to Stdout, ERROR and FATAL to Stderr
+func newRouting() *routing {
+	return &routing{
+		components: make(map[string]LogLevel),
+		sinks:      [len(LogLevelStr)]io.Writer{os.Stdout, os.Stdout, os.Stderr, os.Stderr},
+	}
+}
+
+func (r *routing) enabled(component string, level LogLevel) bool {
+	if level == FATAL {
+		return true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	min, ok := r.components[component]
+	if !ok {
+		min = r.min
+	}
+	return level >= min
+}
+
+//write writes the encoded line to the sink of the level
+func (r *routing) write(level LogLevel, line []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.sinks[level].Write(line)
+}
+
+//Component returns a logger for the named component (e.g. memstore, workpool, server).
+//Messages of the component have a "component" field and are filtered by the level set by SetComponentLevel.
+func (l *AsyncLogger) Component(name string) *AsyncLogger {
+	child := l.With(F("component", name))
+	child.component = name
+	return child
+}
+
+//Enabled reports whether a message with the given level would be written by this logger
+func (l *AsyncLogger) Enabled(level LogLevel) bool {
+	return l.routing.enabled(l.component, level)
+}
+
+//SetLevel sets the minimum level of messages written, INFO by default
+func (l *AsyncLogger) SetLevel(level LogLevel) {
+	l.routing.mu.Lock()
+	l.routing.min = level
+	l.routing.mu.Unlock()
+}
+
+//SetComponentLevel sets the minimum level of the component overriding the level of the logger
+func (l *AsyncLogger) SetComponentLevel(component string, level LogLevel) {
+	l.routing.mu.Lock()
+	l.routing.components[component] = level
+	l.routing.mu.Unlock()
+}
+
+//ResetComponentLevel removes the level of the component so that the level of the logger applies again
+func (l *AsyncLogger) ResetComponentLevel(component string) {
+	l.routing.mu.Lock()
+	delete(l.routing.components, component)
+	l.routing.mu.Unlock()
+}
+
+//Levels returns the current minimum level and levels of components
+func (l *AsyncLogger) Levels() Levels {
+	l.routing.mu.RLock()
+	defer l.routing.mu.RUnlock()
+	levels := Levels{Level: l.routing.min.string(), Components: make(map[string]string)}
+	for component, level := range l.routing.components {
+		levels.Components[component] = level.string()
+	}
+	return levels
+}
+
+//SetSink changes where messages of the level are written.
+//Writes are done by the single logger goroutine so sink does not need to be safe for concurrent use.
+func (l *AsyncLogger) SetSink(level LogLevel, sink io.Writer) {
+	l.routing.mu.Lock()
+	l.routing.sinks[level] = sink
+	l.routing.mu.Unlock()
+}
+
+//reopeners returns the distinct sinks implementing Reopener
+func (r *routing) reopeners() []Reopener {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var reopeners []Reopener
+	seen := make(map[Reopener]bool)
+	for _, sink := range r.sinks {
+		if reopener, ok := sink.(Reopener); ok && !seen[reopener] {
+			seen[reopener] = true
+			reopeners = append(reopeners, reopener)
+		}
+	}
+	return reopeners
+}
+
+//Reopen reopens the files of sinks implementing Reopener, e.g. on SIGHUP after the files are moved by logrotate.
+//Returns the first error but tries every sink.
+func (l *AsyncLogger) Reopen() error {
+	var first error
+	for _, reopener := range l.routing.reopeners() {
+		if err := reopener.Reopen(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
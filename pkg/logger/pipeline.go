@@ -0,0 +1,225 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+//OverflowPolicy defines what happens to a message logged while the buffer is full
+type OverflowPolicy uint8
+
+const (
+	//Block makes the caller wait until there is room in the buffer, no message is lost
+	Block OverflowPolicy = iota
+	//DropOldest discards the oldest buffered message to make room for the new one
+	DropOldest
+	//DropNewest discards the new message and keeps the buffered ones
+	DropNewest
+)
+
+//OverflowPolicyStr defines names of overflow policies
+var OverflowPolicyStr = [...]string{
+	"block",
+	"drop-oldest",
+	"drop-newest",
+}
+
+func (policy OverflowPolicy) String() string {
+	return OverflowPolicyStr[policy]
+}
+
+//ParseOverflowPolicy returns the policy with the given name
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for i, s := range OverflowPolicyStr {
+		if s == name {
+			return OverflowPolicy(i), nil
+		}
+	}
+	return Block, errors.New("unknown overflow policy " + name)
+}
+
+//ErrClosed is returned by Close if the logger is already closed
+var ErrClosed = errors.New("logger is closed")
+
+/*
+pipeline is a bounded ring buffer between the goroutines logging messages and the single goroutine writing them.
+Messages are appended by the caller goroutine itself, so messages of a producer keep their order
+and no goroutine is created per message.
+Every accepted message gets a sequence number. Flush waits until every message accepted before the call
+has been written or dropped.
+*/
+type pipeline struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+
+	buf    []AsyncLogMsg
+	seqs   []uint64
+	head   int
+	count  int
+	policy OverflowPolicy
+
+	//seq is the sequence number of the last accepted message,
+	//writing is the sequence number of the message being written, 0 if none
+	seq     uint64
+	writing uint64
+	closed  bool
+
+	dropped uint64
+}
+
+func newPipeline(size int, policy OverflowPolicy) *pipeline {
+	if size < 1 {
+		size = 1
+	}
+	p := &pipeline{
+		buf:    make([]AsyncLogMsg, size),
+		seqs:   make([]uint64, size),
+		policy: policy,
+	}
+	p.changed = sync.NewCond(&p.mu)
+	return p
+}
+
+//put appends the message applying the overflow policy if the buffer is full, FATAL messages always wait for room
+//and they are never dropped to make room for another message.
+//It reports whether the message is accepted. Messages put after the pipeline is closed are not accepted,
+//they are counted as dropped except FATAL ones which are written by the caller.
+func (p *pipeline) put(msg AsyncLogMsg) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	policy := p.policy
+	if msg.level == FATAL {
+		policy = Block
+	}
+	for p.count == len(p.buf) && !p.closed {
+		switch policy {
+		case DropNewest:
+			atomic.AddUint64(&p.dropped, 1)
+			return false
+		case DropOldest:
+			if p.dropOldest() {
+				atomic.AddUint64(&p.dropped, 1)
+			} else {
+				p.changed.Wait()
+			}
+		default:
+			p.changed.Wait()
+		}
+	}
+	if p.closed {
+		if msg.level != FATAL {
+			atomic.AddUint64(&p.dropped, 1)
+		}
+		return false
+	}
+
+	p.seq++
+	tail := (p.head + p.count) % len(p.buf)
+	p.buf[tail] = msg
+	p.seqs[tail] = p.seq
+	p.count++
+	p.changed.Broadcast()
+	return true
+}
+
+//take waits for the next message and marks it as being written until done is called.
+//It returns false once the pipeline is closed and drained.
+func (p *pipeline) take() (AsyncLogMsg, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.count == 0 {
+		if p.closed {
+			return AsyncLogMsg{}, false
+		}
+		p.changed.Wait()
+	}
+	p.writing = p.seqs[p.head]
+	msg := p.removeHead()
+	p.changed.Broadcast()
+	return msg, true
+}
+
+//done marks the message returned by take as written
+func (p *pipeline) done() {
+	p.mu.Lock()
+	p.writing = 0
+	p.changed.Broadcast()
+	p.mu.Unlock()
+}
+
+//removeHead removes and returns the oldest message, p.mu must be held
+func (p *pipeline) removeHead() AsyncLogMsg {
+	msg := p.buf[p.head]
+	p.buf[p.head] = AsyncLogMsg{}
+	p.head = (p.head + 1) % len(p.buf)
+	p.count--
+	return msg
+}
+
+//dropOldest removes the oldest buffered message which is not FATAL. Messages before it move one place towards
+//the tail so that their order is kept. It reports false if all buffered messages are FATAL, p.mu must be held.
+func (p *pipeline) dropOldest() bool {
+	for i := 0; i < p.count; i++ {
+		if p.buf[(p.head+i)%len(p.buf)].level == FATAL {
+			continue
+		}
+		for j := i; j > 0; j-- {
+			to, from := (p.head+j)%len(p.buf), (p.head+j-1)%len(p.buf)
+			p.buf[to], p.seqs[to] = p.buf[from], p.seqs[from]
+		}
+		p.removeHead()
+		return true
+	}
+	return false
+}
+
+//flush waits until all messages accepted before the call are written or dropped, or ctx is done
+func (p *pipeline) flush(ctx context.Context) error {
+	//Cond cannot wait on a channel, waiters are woken up when ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.changed.Broadcast()
+			p.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	target := p.seq
+	for p.pending(target) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.changed.Wait()
+	}
+	return nil
+}
+
+//pending reports whether a message with sequence number up to target is buffered or being written, p.mu must be held
+func (p *pipeline) pending(target uint64) bool {
+	if p.writing != 0 && p.writing <= target {
+		return true
+	}
+	return p.count > 0 && p.seqs[p.head] <= target
+}
+
+//close stops accepting messages, messages already buffered are still written
+func (p *pipeline) close() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.closed = true
+	p.changed.Broadcast()
+	return true
+}
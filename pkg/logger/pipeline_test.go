@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//msg returns an INFO message with the given text
+func msg(text string) AsyncLogMsg {
+	return AsyncLogMsg{level: INFO, logMsg: []string{text}}
+}
+
+//takeAll takes the buffered messages and returns their texts
+func takeAll(p *pipeline) []string {
+	var texts []string
+	for {
+		p.mu.Lock()
+		empty := p.count == 0
+		p.mu.Unlock()
+		if empty {
+			return texts
+		}
+		m, _ := p.take()
+		p.done()
+		texts = append(texts, m.logMsg[0])
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy   OverflowPolicy
+		accepted bool
+		dropped  uint64
+		written  []string
+	}{
+		{DropNewest, false, 1, []string{"1", "2", "3"}},
+		{DropOldest, true, 1, []string{"2", "3", "4"}},
+		{Block, true, 0, []string{"1", "2", "3", "4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.policy.String(), func(t *testing.T) {
+			p := newPipeline(3, test.policy)
+			for _, text := range []string{"1", "2", "3"} {
+				if !p.put(msg(text)) {
+					t.Fatalf("message %s is not accepted while there is room", text)
+				}
+			}
+
+			//4th message is logged while the buffer is full
+			accepted := make(chan bool, 1)
+			go func() { accepted <- p.put(msg("4")) }()
+			var written []string
+			if test.policy == Block {
+				select {
+				case <-accepted:
+					t.Fatal("caller does not wait for room")
+				case <-time.After(20 * time.Millisecond):
+				}
+				m, _ := p.take()
+				p.done()
+				written = append(written, m.logMsg[0])
+			}
+			if ok := <-accepted; ok != test.accepted {
+				t.Fatalf("message logged while the buffer is full is accepted: %v, expected %v", ok, test.accepted)
+			}
+
+			written = append(written, takeAll(p)...)
+			if len(written) != len(test.written) {
+				t.Fatalf("written %v, expected %v", written, test.written)
+			}
+			for i := range written {
+				if written[i] != test.written[i] {
+					t.Fatalf("written %v, expected %v", written, test.written)
+				}
+			}
+			if p.dropped != test.dropped {
+				t.Fatalf("%d messages are dropped, expected %d", p.dropped, test.dropped)
+			}
+		})
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{Block, DropOldest, DropNewest} {
+		if parsed, err := ParseOverflowPolicy(policy.String()); err != nil || parsed != policy {
+			t.Errorf("%s is parsed as %s: %v", policy, parsed, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop"); err == nil {
+		t.Error("unknown policy is parsed")
+	}
+}
+
+func TestPipelineFlush(t *testing.T) {
+	p := newPipeline(3, Block)
+	p.put(msg("1"))
+	m, _ := p.take()
+
+	//message being written is not flushed until it is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("flush of a message being written returns %v", err)
+	}
+
+	p.done()
+	if err := p.flush(context.Background()); err != nil || m.logMsg[0] != "1" {
+		t.Fatalf("flush returns %v after the message is written", err)
+	}
+}
@@ -0,0 +1,544 @@
+package memstore
+
+import (
+	"../logger"
+	"../model"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.db"
+
+	//every record is prefixed by payload length and crc32 checksum of the payload
+	recordHeaderSize = 8
+	maxRecordSize    = 16 << 20
+)
+
+//log entry operations
+const (
+	opInsert   = "insert"
+	opUpdate   = "update"
+	opDelete   = "delete"
+	opSnapshot = "snapshot"
+)
+
+//ErrCorrupted is returned when a storage file has a record which fails the checksum or cannot be decoded
+var ErrCorrupted = errors.New("storage file is corrupted")
+
+//ErrUnsupportedValue is returned when durable storage is asked to persist a value other than metadata
+var ErrUnsupportedValue = errors.New("durable storage only persists application metadata")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//FsyncPolicy defines when the write-ahead log is flushed to disk
+type FsyncPolicy uint8
+
+const (
+	FsyncAlways   FsyncPolicy = iota //fsync after every record, nothing acknowledged is lost
+	FsyncInterval                    //fsync periodically, at most FsyncInterval of writes can be lost
+	FsyncNever                       //leave flushing to the operating system
+)
+
+//FsyncPolicyStr defines names of fsync policies used in configuration
+var FsyncPolicyStr = [...]string{
+	"always",
+	"interval",
+	"never",
+}
+
+func (policy FsyncPolicy) String() string {
+	return FsyncPolicyStr[policy]
+}
+
+//ParseFsyncPolicy returns the policy with the given name
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	for i, s := range FsyncPolicyStr {
+		if s == name {
+			return FsyncPolicy(i), nil
+		}
+	}
+	return FsyncInterval, errors.New("unknown fsync policy " + name)
+}
+
+//DurableOptions defines fsync and snapshot behaviour of durable storage
+type DurableOptions struct {
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+
+	//SnapshotEvery defines after how many log records a snapshot is written
+	//and the write-ahead log is truncated. Zero means snapshot only on Close.
+	SnapshotEvery int
+
+	//Logger is used from the start, so that what is recovered or cut while opening the storage is logged
+	Logger *logger.AsyncLogger
+}
+
+//walEntry is a single record of the write-ahead log and the snapshot.
+//Seq is increased for every write, so that records already in the snapshot
+//are skipped when the log is replayed.
+type walEntry struct {
+	Seq   uint64          `json:"seq"`
+	Op    string          `json:"op"`
+	Key   string          `json:"key,omitempty"`
+	Value *model.Metadata `json:"value,omitempty"`
+	Count int             `json:"count,omitempty"`
+}
+
+//durableDB keeps the records in an in-memory storage and appends every write to a
+//write-ahead log before it is applied. Periodically the whole storage is written as a
+//compact snapshot and the log is truncated. On startup snapshot and log are replayed.
+//Writes are serialized so that order in the log is the order they are applied.
+type durableDB struct {
+	mu            sync.Mutex
+	mem           *memDB
+	dir           string
+	opts          DurableOptions
+	log           *logger.AsyncLogger
+	wal           *os.File
+	walSize       int64
+	seq           uint64
+	sinceSnapshot int
+	dirty         bool
+	stop          chan bool
+	closeOnce     sync.Once
+	closeErr      error
+}
+
+//CreateDurableDB opens (or creates) the durable storage in given directory.
+//Snapshot and write-ahead log found in the directory are replayed. A truncated or
+//corrupted last record of the log, which is what a crash in the middle of a write leaves behind,
+//is cut off. A corrupted record followed by valid ones is an error, so is a corrupted snapshot
+//since snapshots are replaced atomically.
+func CreateDurableDB(dir string, opts DurableOptions) (*durableDB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db := &durableDB{
+		mem:  CreateInMemDB(),
+		dir:  dir,
+		opts: opts,
+		stop: make(chan bool),
+	}
+	if opts.Logger != nil {
+		db.log = opts.Logger.Component("memstore")
+	}
+	if err := db.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := db.replayWAL(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	db.wal = wal
+
+	if opts.Fsync == FsyncInterval && opts.FsyncInterval > 0 {
+		go db.syncLoop()
+	}
+	//set after replay, so that replayed records are not logged one by one
+	if opts.Logger != nil {
+		db.mem.SetLogger(opts.Logger)
+	}
+	if db.log != nil {
+		db.log.Log(logger.INFO, "Durable memstore has been opened in ", dir, " at sequence ", strconv.FormatUint(db.seq, 10))
+	}
+	return db, nil
+}
+
+//Insert logs and inserts given key val pair into the storage
+func (db *durableDB) Insert(ctx context.Context, key string, val interface{}) error {
+	metadata, ok := val.(model.Metadata)
+	if !ok {
+		return ErrUnsupportedValue
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if db.mem.Read(key) != nil {
+		return ErrConflict
+	}
+	if err := db.append(walEntry{Op: opInsert, Key: key, Value: &metadata}); err != nil {
+		return err
+	}
+	//record is in the log, so it is applied whatever happens to ctx
+	db.mem.Insert(context.Background(), key, metadata)
+	db.afterWrite()
+	return nil
+}
+
+//Update logs and replaces the value of an existing key
+func (db *durableDB) Update(ctx context.Context, key string, val interface{}) error {
+	metadata, ok := val.(model.Metadata)
+	if !ok {
+		return ErrUnsupportedValue
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if db.mem.Read(key) == nil {
+		return ErrNotFound
+	}
+	if err := db.append(walEntry{Op: opUpdate, Key: key, Value: &metadata}); err != nil {
+		return err
+	}
+	db.mem.Update(context.Background(), key, metadata)
+	db.afterWrite()
+	return nil
+}
+
+//Delete logs and removes an existing key from the storage
+func (db *durableDB) Delete(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if db.mem.Read(key) == nil {
+		return ErrNotFound
+	}
+	if err := db.append(walEntry{Op: opDelete, Key: key}); err != nil {
+		return err
+	}
+	db.mem.Delete(context.Background(), key)
+	db.afterWrite()
+	return nil
+}
+
+//Read reads a record with the given key
+func (db *durableDB) Read(key string) interface{} {
+	return db.mem.Read(key)
+}
+
+//ReadWithParams queries the storage for objects match the given url query strings
+func (db *durableDB) ReadWithParams(params map[string][]string) ([]interface{}, error) {
+	return db.mem.ReadWithParams(params)
+}
+
+//Search queries the storage and returns the requested page of the ordered result
+func (db *durableDB) Search(ctx context.Context, params map[string][]string, page PageRequest) (Page, error) {
+	return db.mem.Search(ctx, params, page)
+}
+
+func (db *durableDB) SetLogger(logger *logger.AsyncLogger) {
+	db.mem.SetLogger(logger)
+	db.mu.Lock()
+	db.log = logger.Component("memstore")
+	db.mu.Unlock()
+}
+
+//Close writes a final snapshot, flushes the log and closes it.
+//Storage is closed only once, later calls return the result of the first one.
+func (db *durableDB) Close() error {
+	db.closeOnce.Do(func() {
+		close(db.stop)
+
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if err := db.snapshot(); err != nil {
+			db.wal.Close()
+			db.closeErr = err
+			return
+		}
+		db.closeErr = db.wal.Close()
+	})
+	return db.closeErr
+}
+
+//append writes the entry to the write-ahead log with the next sequence number.
+//If write fails, log is truncated back so that it does not end with a partial record.
+func (db *durableDB) append(entry walEntry) error {
+	entry.Seq = db.seq + 1
+
+	n, err := writeRecord(db.wal, entry)
+	if err == nil && db.opts.Fsync == FsyncAlways {
+		err = db.wal.Sync()
+	}
+	if err != nil {
+		db.wal.Truncate(db.walSize)
+		return err
+	}
+
+	db.walSize += int64(n)
+	db.seq = entry.Seq
+	db.dirty = true
+	return nil
+}
+
+//afterWrite writes a snapshot once enough records have been appended to the log
+func (db *durableDB) afterWrite() {
+	db.sinceSnapshot++
+	if db.opts.SnapshotEvery > 0 && db.sinceSnapshot >= db.opts.SnapshotEvery {
+		if err := db.snapshot(); err != nil && db.log != nil {
+			db.log.Log(logger.ERROR, "Snapshot of durable memstore failed: ", err.Error())
+		}
+	}
+}
+
+//snapshot writes all records into a temporary file which then atomically replaces
+//the snapshot. Write-ahead log is truncated afterwards since all its records are in the snapshot.
+//Caller must hold the lock.
+func (db *durableDB) snapshot() error {
+	tmpPath := filepath.Join(db.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	var entries []walEntry
+	db.mem.each(func(key string, val interface{}) {
+		if metadata, ok := val.(model.Metadata); ok {
+			entries = append(entries, walEntry{Seq: db.seq, Op: opInsert, Key: key, Value: &metadata})
+		}
+	})
+
+	w := bufio.NewWriter(f)
+	_, err = writeRecord(w, walEntry{Seq: db.seq, Op: opSnapshot, Count: len(entries)})
+	for i := 0; err == nil && i < len(entries); i++ {
+		_, err = writeRecord(w, entries[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(db.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(db.dir); err != nil {
+		return err
+	}
+
+	//records in the log are now part of the snapshot. Even if truncate fails,
+	//they are skipped on replay since their sequence is not greater than the snapshot's.
+	if err := db.wal.Truncate(0); err != nil {
+		return err
+	}
+	db.walSize = 0
+	db.sinceSnapshot = 0
+	db.dirty = false
+
+	if db.log != nil {
+		db.log.Log(logger.INFO, "Snapshot of durable memstore has been written at sequence ", strconv.FormatUint(db.seq, 10))
+	}
+	return nil
+}
+
+//syncLoop flushes the write-ahead log periodically until storage is closed
+func (db *durableDB) syncLoop() {
+	ticker := time.NewTicker(db.opts.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			db.mu.Lock()
+			if db.dirty {
+				if err := db.wal.Sync(); err != nil && db.log != nil {
+					db.log.Log(logger.ERROR, "Fsync of write-ahead log failed: ", err.Error())
+				}
+				db.dirty = false
+			}
+			db.mu.Unlock()
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+//loadSnapshot reads the snapshot into memory. Missing snapshot is not an error.
+func (db *durableDB) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(db.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, _, err := readRecord(r)
+	if err != nil || header.Op != opSnapshot {
+		return fmt.Errorf("%s: %v", snapshotFileName, ErrCorrupted)
+	}
+	for i := 0; i < header.Count; i++ {
+		entry, _, err := readRecord(r)
+		if err != nil || entry.Value == nil {
+			return fmt.Errorf("%s: record %d: %v", snapshotFileName, i, ErrCorrupted)
+		}
+		db.mem.Insert(context.Background(), entry.Key, *entry.Value)
+	}
+	db.seq = header.Seq
+	return nil
+}
+
+//replayWAL applies the records of the write-ahead log which are newer than the snapshot.
+//Log is cut at a truncated or corrupted record if it is the last one. If there are valid records after it,
+//the log is corrupted in the middle and it is an error, cutting it there would lose the writes in those records.
+func (db *durableDB) replayWAL() error {
+	path := filepath.Join(db.dir, walFileName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		entry, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			followed, readErr := followedByRecords(path, offset)
+			if readErr != nil {
+				return readErr
+			}
+			if followed {
+				return fmt.Errorf("%s: offset %d is followed by valid records: %w", walFileName, offset, ErrCorrupted)
+			}
+			if db.log != nil {
+				db.log.Log(logger.WARNING, "Write-ahead log is cut at offset ", strconv.FormatInt(offset, 10), ": ", err.Error())
+			}
+			if err := os.Truncate(path, offset); err != nil {
+				return err
+			}
+			break
+		}
+		offset += int64(n)
+
+		if entry.Seq <= db.seq {
+			continue
+		}
+		switch entry.Op {
+		case opInsert, opUpdate:
+			if entry.Value == nil {
+				return fmt.Errorf("%s: sequence %d: %v", walFileName, entry.Seq, ErrCorrupted)
+			}
+			db.mem.put(entry.Key, *entry.Value)
+		case opDelete:
+			db.mem.Delete(context.Background(), entry.Key)
+		}
+		db.seq = entry.Seq
+		db.sinceSnapshot++
+	}
+	db.walSize = offset
+	return nil
+}
+
+//followedByRecords reports whether there is a valid record after the invalid one at offset of the log.
+//Length of the invalid record cannot be trusted, so a record is looked for at every position after offset.
+func followedByRecords(path string, offset int64) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	tail := data[offset:]
+	for i := 1; i+recordHeaderSize <= len(tail); i++ {
+		if size := binary.BigEndian.Uint32(tail[i : i+4]); int64(size) > int64(len(tail)-i-recordHeaderSize) {
+			continue
+		}
+		if _, _, err := readRecord(bytes.NewReader(tail[i:])); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//writeRecord writes the entry as a single length and checksum prefixed record
+func writeRecord(w io.Writer, entry walEntry) (int, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderSize:], payload)
+
+	return w.Write(record)
+}
+
+//readRecord reads a single record and verifies its checksum.
+//Returns io.EOF only if there is nothing left to read, io.ErrUnexpectedEOF
+//if the record is truncated and ErrCorrupted if it fails the checksum.
+func readRecord(r io.Reader) (walEntry, int, error) {
+	var entry walEntry
+
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return entry, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return entry, 0, ErrCorrupted
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return entry, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return entry, 0, ErrCorrupted
+	}
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return entry, 0, ErrCorrupted
+	}
+	return entry, recordHeaderSize + int(size), nil
+}
+
+//syncDir flushes directory entry changes such as a rename
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
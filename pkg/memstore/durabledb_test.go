@@ -0,0 +1,125 @@
+package memstore
+
+import (
+	"../logger"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//crashedDB writes the records into a durable storage in dir and leaves it without Close like a crash,
+//so that the records are only in the write-ahead log. It returns the offsets where the records start.
+func crashedDB(t *testing.T, dir string, records int) []int64 {
+	db, err := CreateDurableDB(dir, DurableOptions{Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for i := 0; i < records; i++ {
+		offsets = append(offsets, db.walSize)
+		m := testMetadata(i)
+		if err := db.Insert(context.Background(), m.Key(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.wal.Close()
+	return offsets
+}
+
+//corrupt flips a byte in the payload of the record at offset
+func corrupt(t *testing.T, path string, offset int64) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset+recordHeaderSize+1] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayTornLastRecord(t *testing.T) {
+	for name, tear := range map[string]func(t *testing.T, path string, last int64){
+		"truncated": func(t *testing.T, path string, last int64) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"corrupted": func(t *testing.T, path string, last int64) {
+			corrupt(t, path, last)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			offsets := crashedDB(t, dir, 3)
+			path := filepath.Join(dir, walFileName)
+			tear(t, path, offsets[2])
+
+			log := logger.CreateAsyncLogger()
+			var logged bytes.Buffer
+			for level := range logger.LogLevelStr {
+				log.SetSink(logger.LogLevel(level), &logged)
+			}
+			db, err := CreateDurableDB(dir, DurableOptions{Fsync: FsyncNever, Logger: log})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			//cut is logged by the logger given to open, the application has no other chance to set it before replay
+			if err := log.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(logged.String(), "Write-ahead log is cut at offset "+strconv.FormatInt(offsets[2], 10)) {
+				t.Fatalf("cut of the log is not logged: %s", logged.String())
+			}
+			if res, _ := db.ReadWithParams(nil); len(res) != 2 {
+				t.Fatalf("%d records are replayed, expected the 2 before the torn one", len(res))
+			}
+			if info, _ := os.Stat(path); info.Size() != offsets[2] {
+				t.Fatalf("log is cut at %d, expected %d", info.Size(), offsets[2])
+			}
+		})
+	}
+}
+
+func TestReplayCorruptedMiddleRecord(t *testing.T) {
+	dir := t.TempDir()
+	offsets := crashedDB(t, dir, 3)
+	path := filepath.Join(dir, walFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, path, offsets[1])
+
+	if _, err := CreateDurableDB(dir, DurableOptions{Fsync: FsyncNever}); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("error %v, expected %v", err, ErrCorrupted)
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Fatalf("log corrupted in the middle is cut from %d to %d", info.Size(), after.Size())
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	db, err := CreateDurableDB(t.TempDir(), DurableOptions{Fsync: FsyncInterval, FsyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package memstore
+
+import (
+	"../model"
+	"sort"
+)
+
+//indexedFields are the search parameters which are full string matches
+//and have a secondary index maintained by the storage
+var indexedFields = []string{
+	"id",
+	"company",
+	"license",
+	"source",
+	"website",
+	"maintainers.name",
+	"maintainers.email",
+}
+
+//postings is the set of keys of the records having a value
+type postings map[string]struct{}
+
+//fieldIndex maps each value of a field to the keys of the records having that value
+type fieldIndex map[string]postings
+
+//indexes keeps a secondary index per indexed field.
+//Indexes are updated together with the records under the write lock of the storage.
+type indexes map[string]fieldIndex
+
+func newIndexes() indexes {
+	ix := make(indexes)
+	for _, field := range indexedFields {
+		ix[field] = make(fieldIndex)
+	}
+	return ix
+}
+
+//add indexes the record stored with the given key
+func (ix indexes) add(key string, rec *record) {
+	for field, fieldIx := range ix {
+		for _, val := range indexValues(rec, field) {
+			if fieldIx[val] == nil {
+				fieldIx[val] = make(postings)
+			}
+			fieldIx[val][key] = struct{}{}
+		}
+	}
+}
+
+//remove drops the record stored with the given key from the indexes
+func (ix indexes) remove(key string, rec *record) {
+	for field, fieldIx := range ix {
+		for _, val := range indexValues(rec, field) {
+			delete(fieldIx[val], key)
+			if len(fieldIx[val]) == 0 {
+				delete(fieldIx, val)
+			}
+		}
+	}
+}
+
+//indexValues returns the values of a field of the record, maintainer fields may have many
+func indexValues(rec *record, field string) []string {
+	metadata, ok := rec.val.(model.Metadata)
+	if !ok {
+		return nil
+	}
+
+	switch field {
+	case "id":
+		return []string{metadata.AppID()}
+	case "company":
+		return []string{metadata.Company}
+	case "license":
+		return []string{metadata.License}
+	case "source":
+		return []string{metadata.Source}
+	case "website":
+		return []string{metadata.Website}
+	case "maintainers.name", "maintainers.email":
+		var values []string
+		for _, maintainer := range metadata.Maintainers {
+			if field == "maintainers.name" {
+				values = append(values, maintainer.Name)
+			} else {
+				values = append(values, maintainer.Email)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+//plan picks the candidate records for the search using the indexes.
+//Postings of every indexed parameter value are intersected starting from the smallest one.
+//Returns false if there is no indexed parameter in the search, in which case all records
+//have to be scanned. Candidates still have to be checked against all the parameters,
+//e.g. for title and description which are "contains" checks.
+func (ix indexes) plan(params map[string][]string) ([]string, bool) {
+	var lists []postings
+	for _, field := range indexedFields {
+		values, ok := params[field]
+		if !ok {
+			continue
+		}
+		for _, val := range values {
+			lists = append(lists, ix[field][val])
+		}
+	}
+	if len(lists) == 0 {
+		return nil, false
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	})
+
+	var candidates []string
+	for key := range lists[0] {
+		found := true
+		for _, list := range lists[1:] {
+			if _, ok := list[key]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			candidates = append(candidates, key)
+		}
+	}
+	return candidates, true
+}
@@ -0,0 +1,73 @@
+package memstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+const benchRecords = 50000
+
+var (
+	benchOnce sync.Once
+	benchDB   *memDB
+)
+
+//benchStore returns the store shared by the benchmarks, records are spread over 100 companies
+func benchStore(b *testing.B) *memDB {
+	benchOnce.Do(func() {
+		benchDB = CreateInMemDB()
+		for i := 0; i < benchRecords; i++ {
+			m := testMetadata(i)
+			if err := benchDB.Insert(context.Background(), m.Key(), m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	return benchDB
+}
+
+//benchQueries are given to both paths: an exact match of an indexed field
+//and the same one together with a contains predicate which is checked on every candidate
+var benchQueries = []struct {
+	name   string
+	params map[string][]string
+}{
+	{"exact", map[string][]string{"company": {"company-42"}}},
+	{"contains", map[string][]string{"company": {"company-42"}, "title": {"app 1"}}},
+}
+
+//BenchmarkReadWithParamsIndexed reads through the planner, only the records in the index of the company are checked
+func BenchmarkReadWithParamsIndexed(b *testing.B) {
+	db := benchStore(b)
+	for _, query := range benchQueries {
+		b.Run(query.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.ReadWithParams(query.params); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+//BenchmarkReadWithParamsScan checks every record against the same queries as the store does when there is no indexed parameter
+func BenchmarkReadWithParamsScan(b *testing.B) {
+	db := benchStore(b)
+	for _, query := range benchQueries {
+		b.Run(query.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.mu.RLock()
+				var res []interface{}
+				for _, rec := range db.keyValDB {
+					if checkModelWithParams(rec, query.params, nil) {
+						res = append(res, copyValue(rec.val))
+					}
+				}
+				db.mu.RUnlock()
+			}
+		})
+	}
+}
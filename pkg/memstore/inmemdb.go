@@ -1,9 +1,13 @@
 package memstore
 
 import (
+	"../fulltext"
 	"../logger"
 	"../model"
+	"../semver"
+	"context"
 	"strings"
+	"sync"
 )
 
 //dedicated logger for storage operations
@@ -11,8 +15,39 @@ var db_logger *logger.AsyncLogger
 
 //underlying structure to record key-value object
 //value can be any type
+//Workers write to the storage while handlers read from it concurrently,
+//so map is guarded by a read/write lock. Readers get copies of the records
+//so that they always see a consistent view even if a record is replaced meanwhile.
+//Secondary indexes are maintained for full string match fields so that searches
+//do not need to scan all the records. Title and description are kept in a full-text index.
 type memDB struct {
-	keyValDB map[string]interface{}
+	mu       sync.RWMutex
+	keyValDB map[string]*record
+	indexes  indexes
+	text     *fulltext.Index
+}
+
+//textBoosts defines full-text indexed fields, a match in title is more relevant than in description
+var textBoosts = map[string]float64{
+	"title":       2.0,
+	"description": 1.0,
+}
+
+//record is a stored value together with its parsed semantic version,
+//so that version constraints can be checked without parsing on every search.
+//version is nil if the value is not metadata or its version is not a semantic version.
+type record struct {
+	val     interface{}
+	version *semver.Version
+}
+
+//newRecord creates the record of a copy of the given value
+func newRecord(val interface{}) *record {
+	rec := &record{val: copyValue(val)}
+	if metadata, ok := val.(model.Metadata); ok {
+		rec.version, _ = semver.Parse(metadata.Version)
+	}
+	return rec
 }
 
 //CreateInMemDB creates the underlying storage and logger
@@ -20,81 +55,290 @@ func CreateInMemDB() *memDB {
 	if db_logger != nil {
 		defer db_logger.Log(logger.INFO, "In-Memory memstore has been created")
 	}
-	return &memDB{make(map[string]interface{})}
+	return &memDB{
+		keyValDB: make(map[string]*record),
+		indexes:  newIndexes(),
+		text:     fulltext.NewIndex(textBoosts),
+	}
 }
 
 //Insert inserts given key val pair into the storage
-func (db *memDB) Insert(key string, val interface{}) {
-	db.keyValDB[key] = val
+func (db *memDB) Insert(ctx context.Context, key string, val interface{}) error {
+	db.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if _, ok := db.keyValDB[key]; ok {
+		db.mu.Unlock()
+		return ErrConflict
+	}
+	db.set(key, newRecord(val))
+	db.mu.Unlock()
 	if db_logger != nil {
 		db_logger.Log(logger.INFO, "Value has been inserted to in-memory memstore with key: ", key)
 	}
+	return nil
+}
+
+//Update replaces the value of an existing key
+func (db *memDB) Update(ctx context.Context, key string, val interface{}) error {
+	db.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if _, ok := db.keyValDB[key]; !ok {
+		db.mu.Unlock()
+		return ErrNotFound
+	}
+	db.set(key, newRecord(val))
+	db.mu.Unlock()
+	if db_logger != nil {
+		db_logger.Log(logger.INFO, "Value has been updated in in-memory memstore with key: ", key)
+	}
+	return nil
 }
 
+//Delete removes an existing key from the storage
+func (db *memDB) Delete(ctx context.Context, key string) error {
+	db.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if _, ok := db.keyValDB[key]; !ok {
+		db.mu.Unlock()
+		return ErrNotFound
+	}
+	db.unset(key)
+	db.mu.Unlock()
+	if db_logger != nil {
+		db_logger.Log(logger.INFO, "Value has been deleted from in-memory memstore with key: ", key)
+	}
+	return nil
+}
+
+//SetLogger sets the logger of storage, messages are logged as "memstore" component
 func (db *memDB) SetLogger(logger *logger.AsyncLogger) {
-	db_logger = logger
+	db_logger = logger.Component("memstore")
+}
+
+//Close does nothing for in-memory storage, records are simply gone with the process
+func (db *memDB) Close() error {
+	return nil
+}
+
+//put sets the value of the key whether it exists or not.
+//Used when replaying records which have already been checked once.
+func (db *memDB) put(key string, val interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.set(key, newRecord(val))
+}
+
+//set stores the record and updates the indexes. Caller must hold the write lock.
+func (db *memDB) set(key string, rec *record) {
+	db.unset(key)
+	db.keyValDB[key] = rec
+	db.indexes.add(key, rec)
+	if metadata, ok := rec.val.(model.Metadata); ok {
+		db.text.Add(key, map[string]string{
+			"title":       metadata.Title,
+			"description": metadata.Description,
+		})
+	}
+}
+
+//unset removes the record and its index entries if it exists. Caller must hold the write lock.
+func (db *memDB) unset(key string) {
+	if old, ok := db.keyValDB[key]; ok {
+		db.indexes.remove(key, old)
+		db.text.Remove(key)
+		delete(db.keyValDB, key)
+	}
+}
+
+//each calls fn for a copy of every record under the read lock
+func (db *memDB) each(fn func(key string, val interface{})) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for key, rec := range db.keyValDB {
+		fn(key, copyValue(rec.val))
+	}
 }
 
 //ReadWithParams queries the storage for objects match the given url query strings
-func (db *memDB) ReadWithParams(params map[string][]string) []interface{} {
+//Whole search is done under the read lock so that result is a consistent snapshot of the storage.
+//version parameter is either an exact version or a constraint like ">=1.2.0 <2.0.0" or "~1.4".
+//Returns ErrInvalidQuery if version constraint cannot be parsed.
+//Order of the result is not defined, Search should be used for ordered and paged results.
+func (db *memDB) ReadWithParams(params map[string][]string) ([]interface{}, error) {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	entries, err := db.filter(params)
+	if err != nil {
+		return nil, err
+	}
 
 	var res []interface{}
+	for _, e := range entries {
+		res = append(res, copyValue(e.rec.val))
+	}
+	return res, nil
+}
+
+//filter returns the records matching given url query strings. Caller must hold the lock.
+func (db *memDB) filter(params map[string][]string) ([]entry, error) {
+
+	var res []entry
+
+	constraint, err := parseVersionConstraint(params)
+	if err != nil {
+		return nil, err
+	}
 
 	//If there is no search criteria then return all records
-	//TODO: Paging should be done here for performance issues
 	if len(params) == 0 {
-		for _, model := range db.keyValDB {
-			res = append(res, model)
+		for key, rec := range db.keyValDB {
+			res = append(res, entry{key: key, rec: rec})
+		}
+		return res, nil
+	}
+
+	//If there are both id and exact version in query then use them as key.
+	//if there is key then there is no need to check other parameters as well
+	if id, ok := params["id"]; ok {
+		if version, ok := params["version"]; ok && constraint == nil {
+			key := model.Key(id[0], version[0])
+			if rec, ok := db.keyValDB[key]; ok {
+				res = append(res, entry{key: key, rec: rec})
+			}
+			return res, nil
 		}
-		return res
 	}
 
-	//If there is version in query then use it as key.
-	//if there is version then there is no need to check other parameters as well
-	if i, ok := params["version"]; ok {
-		return append(res, db.Read(i[0]))
+	//If there is a full-text query then only the records matching it are checked
+	//and they are scored by relevance
+	if q, ok := params["q"]; ok {
+		scores := db.text.Search(strings.Join(q, " "))
+		candidates, planned := db.indexes.plan(params)
+		if !planned {
+			for key := range scores {
+				candidates = append(candidates, key)
+			}
+		}
+		for _, key := range candidates {
+			score, matched := scores[key]
+			rec := db.keyValDB[key]
+			if matched && checkModelWithParams(rec, params, constraint) {
+				res = append(res, entry{key: key, rec: rec, score: score})
+			}
+		}
+		return res, nil
+	}
+
+	//If there are indexed parameters then only the records in the intersection
+	//of their indexes are checked, otherwise all records are scanned
+	if candidates, ok := db.indexes.plan(params); ok {
+		for _, key := range candidates {
+			rec := db.keyValDB[key]
+			if checkModelWithParams(rec, params, constraint) {
+				res = append(res, entry{key: key, rec: rec})
+			}
+		}
+		return res, nil
 	}
 
 	//check all records which match given query string
-	for _, model := range db.keyValDB {
-		if checkModelWithParams(model, params) {
-			res = append(res, model)
+	for key, rec := range db.keyValDB {
+		if checkModelWithParams(rec, params, constraint) {
+			res = append(res, entry{key: key, rec: rec})
 		}
 	}
-	return res
+	return res, nil
+}
+
+//parseVersionConstraint returns the constraint given as version parameter.
+//Returns nil if there is no version parameter or it is an exact version.
+func parseVersionConstraint(params map[string][]string) (*semver.Constraint, error) {
+	version, ok := params["version"]
+	if !ok || len(version) != 1 || semver.IsValid(version[0]) {
+		return nil, nil
+	}
+	constraint, err := semver.ParseConstraint(version[0])
+	if err != nil {
+		return nil, ErrInvalidQuery
+	}
+	return constraint, nil
 }
 
 //Read reads a record with the given key
 func (db *memDB) Read(key string) interface{} {
-	if val, ok := db.keyValDB[key]; ok {
-		return val
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.read(key)
+}
+
+//read returns a copy of the record with the given key. Caller must hold the lock.
+func (db *memDB) read(key string) interface{} {
+	if rec, ok := db.keyValDB[key]; ok {
+		return copyValue(rec.val)
 	}
 	return nil
 }
 
+//copyValue returns a copy of the value which does not share memory with the stored one.
+//Metadata is a struct so it is copied by value except maintainers slice which is copied explicitly.
+func copyValue(val interface{}) interface{} {
+	if metadata, ok := val.(model.Metadata); ok {
+		metadata.Maintainers = append([]model.MaintainPerson(nil), metadata.Maintainers...)
+		return metadata
+	}
+	return val
+}
+
 //checkModelWithParams compares given object with the query string in case there is a match
-//for query params title and description, it is checked if it is contained
+//for query params title and description, it is checked case-insensitively if it is contained
 //for other fields full string match is expected
 //if title is "App v1.0.0" then a query with title=app will match
 //if description is "This is a description for app" then description=for%20app will match
-func checkModelWithParams(data interface{}, urlQuerystr map[string][]string) bool {
+//for version either full string match or the given constraint is checked against parsed version
+func checkModelWithParams(rec *record, urlQuerystr map[string][]string, constraint *semver.Constraint) bool {
 
-	metadata, ok := data.(model.Metadata)
+	metadata, ok := rec.val.(model.Metadata)
 
 	if ok {
 
 		for param, value := range urlQuerystr {
-			if (param != "maintainers.name" && param != "maintainers.email") && len(value) > 1 {
+			if (param != "maintainers.name" && param != "maintainers.email" && param != "q") && len(value) > 1 {
 				return false
 			}
 			switch queryParam := strings.TrimSpace(param); queryParam {
 
+			case "id":
+				if metadata.AppID() != value[0] {
+					return false
+				}
+			case "version":
+				if constraint != nil {
+					if rec.version == nil || !constraint.Check(rec.version) {
+						return false
+					}
+				} else if metadata.Version != value[0] {
+					return false
+				}
 			case "title":
-				if !strings.Contains(metadata.Title, value[0]) {
+				if !strings.Contains(strings.ToLower(metadata.Title), strings.ToLower(value[0])) {
 					return false
 				}
 			case "description":
-				if !strings.Contains(metadata.Description, value[0]) {
+				if !strings.Contains(strings.ToLower(metadata.Description), strings.ToLower(value[0])) {
 					return false
 				}
 			case "company":
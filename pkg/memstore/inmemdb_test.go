@@ -0,0 +1,119 @@
+package memstore
+
+import (
+	"../model"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+//testMetadata returns a valid metadata whose fields depend on i, so that searches match a part of the records
+func testMetadata(i int) model.Metadata {
+	return model.Metadata{
+		ID:          fmt.Sprintf("app-%d", i),
+		Title:       fmt.Sprintf("App %d", i),
+		Version:     fmt.Sprintf("1.%d.0", i%10),
+		Company:     fmt.Sprintf("company-%d", i%100),
+		Website:     "https://example.com",
+		Source:      "https://github.com/example/app",
+		License:     []string{"Apache-2.0", "MIT", "GPL-3.0"}[i%3],
+		Maintainers: []model.MaintainPerson{{Name: fmt.Sprintf("maintainer %d", i%50), Email: "dev@example.com"}},
+		Description: fmt.Sprintf("Description of record %d", i),
+	}
+}
+
+//TestConcurrentWritesAndReads runs writers and readers of the same records at the same time.
+//It is meant to be run with -race, readers also check that they always see complete records.
+func TestConcurrentWritesAndReads(t *testing.T) {
+	const (
+		writers    = 8
+		readers    = 8
+		keys       = 200
+		iterations = 500
+	)
+	db := CreateInMemDB()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				n := (w*iterations + i) % keys
+				m := testMetadata(n)
+				key := m.Key()
+				switch i % 3 {
+				case 0:
+					db.Insert(ctx, key, m)
+				case 1:
+					m.Description = fmt.Sprintf("Updated by writer %d", w)
+					db.Update(ctx, key, m)
+				case 2:
+					db.Delete(ctx, key)
+				}
+			}
+		}(w)
+	}
+
+	errs := make(chan error, readers)
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				m := testMetadata(i % keys)
+				if val, ok := db.Read(m.Key()).(model.Metadata); ok {
+					if val.Key() != m.Key() || len(val.Maintainers) != 1 {
+						errs <- fmt.Errorf("read an incomplete record %+v", val)
+						return
+					}
+					//readers get copies, changing them must not change the stored record
+					val.Maintainers[0].Name = "changed by reader"
+				}
+
+				params := map[string][]string{"company": {m.Company}, "license": {m.License}}
+				res, err := db.ReadWithParams(params)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for _, val := range res {
+					if val.(model.Metadata).Company != m.Company {
+						errs <- fmt.Errorf("search returned a record of another company %+v", val)
+						return
+					}
+				}
+
+				page := PageRequest{Limit: 20, Sort: []SortField{{Name: "title"}}}
+				for {
+					result, err := db.Search(ctx, map[string][]string{"q": {"app"}}, page)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if result.NextCursor == "" {
+						break
+					}
+					page.Cursor = result.NextCursor
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	res, err := db.ReadWithParams(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, val := range res {
+		if val.(model.Metadata).Maintainers[0].Name == "changed by reader" {
+			t.Fatalf("stored record is changed through a copy returned to a reader: %+v", val)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package memstore
+
+import (
+	"../model"
+	"../semver"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+//SortField is a field search results are ordered by, Desc reverses the order
+type SortField struct {
+	Name string
+	Desc bool
+}
+
+//sortableFields are the metadata fields results can be ordered by
+var sortableFields = map[string]bool{
+	"id":      true,
+	"title":   true,
+	"version": true,
+	"company": true,
+	"website": true,
+	"source":  true,
+	"license": true,
+	"score":   true,
+}
+
+//PageRequest defines which part of the ordered search result is returned.
+//Cursor is the opaque NextCursor of the previous page, empty for the first page.
+//Results are always ordered by key after the given sort fields so that order is deterministic.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+	Sort   []SortField
+}
+
+//Hit is an item of a full-text search result, a record with its relevance score
+type Hit struct {
+	Score float64     `json:"score" yaml:"score"`
+	App   interface{} `json:"app" yaml:"app"`
+}
+
+//Page is a part of the search result. Total is the number of all records matching the search
+//and NextCursor is empty if this is the last page.
+//Items are Hits if the search has a full-text query (q parameter).
+type Page struct {
+	Items      []interface{}
+	Total      int
+	NextCursor string
+}
+
+//cursor is the position after the last record of a page. It keeps the sort values of that record
+//rather than an offset, so that paging is not affected by records inserted or deleted meanwhile.
+type cursor struct {
+	Sort   string            `json:"s"`
+	Key    string            `json:"k"`
+	Values map[string]string `json:"v"`
+	Score  float64           `json:"sc,omitempty"`
+}
+
+//entry is a record matching a search together with its key
+//and relevance score if the search has a full-text query
+type entry struct {
+	key   string
+	rec   *record
+	score float64
+}
+
+//ParseSort parses sort parameter like "title,-version" where - means descending order.
+//score is the relevance of full-text search.
+func ParseSort(s string) ([]SortField, error) {
+	var fields []SortField
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field := SortField{Name: name}
+		if strings.HasPrefix(name, "-") {
+			field = SortField{Name: name[1:], Desc: true}
+		}
+		if !sortableFields[field.Name] {
+			return nil, ErrInvalidQuery
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+//Search queries the storage like ReadWithParams and returns the requested page of the ordered result.
+//Results of a full-text search are ordered by descending relevance unless another order is requested.
+//Search gives up with the context error if ctx is done before the result is ordered.
+func (db *memDB) Search(ctx context.Context, params map[string][]string, page PageRequest) (Page, error) {
+	_, fullText := params["q"]
+	if fullText && len(page.Sort) == 0 {
+		page.Sort = []SortField{{Name: "score", Desc: true}}
+	}
+
+	var after *cursor
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor)
+		if err != nil || c.Sort != sortString(page.Sort) {
+			return Page{}, ErrInvalidQuery
+		}
+		after = c
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Page{}, err
+	}
+	db.mu.RLock()
+	entries, err := db.filter(params)
+	db.mu.RUnlock()
+	if err != nil {
+		return Page{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Page{}, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return compareEntries(entries[i], entries[j], page.Sort) < 0
+	})
+
+	start := 0
+	if after != nil {
+		position := cursorEntry(after)
+		start = sort.Search(len(entries), func(i int) bool {
+			return compareEntries(entries[i], position, page.Sort) > 0
+		})
+	}
+	end := len(entries)
+	if page.Limit > 0 && start+page.Limit < end {
+		end = start + page.Limit
+	}
+
+	result := Page{Total: len(entries), Items: []interface{}{}}
+	for _, e := range entries[start:end] {
+		if fullText {
+			result.Items = append(result.Items, Hit{Score: e.score, App: copyValue(e.rec.val)})
+		} else {
+			result.Items = append(result.Items, copyValue(e.rec.val))
+		}
+	}
+	if end < len(entries) {
+		result.NextCursor = encodeCursor(entries[end-1], page.Sort)
+	}
+	return result, nil
+}
+
+//compareEntries compares two entries by the sort fields and then by key
+func compareEntries(a entry, b entry, fields []SortField) int {
+	for _, field := range fields {
+		var c int
+		if field.Name == "score" {
+			c = compareScore(a.score, b.score)
+		} else {
+			c = compareField(a.rec, b.rec, field.Name)
+		}
+		if field.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+//compareField compares a field of two records. Versions are compared by semantic version precedence,
+//other fields case-insensitively.
+func compareField(a *record, b *record, name string) int {
+	if name == "version" && a.version != nil && b.version != nil {
+		return a.version.Compare(b.version)
+	}
+	va, vb := fieldValue(a, name), fieldValue(b, name)
+	if c := strings.Compare(strings.ToLower(va), strings.ToLower(vb)); c != 0 {
+		return c
+	}
+	return strings.Compare(va, vb)
+}
+
+func compareScore(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+//fieldValue returns the value of a sortable field of the record
+func fieldValue(rec *record, name string) string {
+	metadata, _ := rec.val.(model.Metadata)
+	switch name {
+	case "id":
+		return metadata.AppID()
+	case "title":
+		return metadata.Title
+	case "version":
+		return metadata.Version
+	case "company":
+		return metadata.Company
+	case "website":
+		return metadata.Website
+	case "source":
+		return metadata.Source
+	case "license":
+		return metadata.License
+	}
+	return ""
+}
+
+//encodeCursor builds the cursor pointing after the given entry
+func encodeCursor(e entry, fields []SortField) string {
+	c := cursor{Sort: sortString(fields), Key: e.key, Values: map[string]string{}, Score: e.score}
+	for _, field := range fields {
+		c.Values[field.Name] = fieldValue(e.rec, field.Name)
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//cursorEntry rebuilds an entry from the values kept in the cursor so that it can be compared with records
+func cursorEntry(c *cursor) entry {
+	metadata := model.Metadata{
+		ID:      c.Values["id"],
+		Title:   c.Values["title"],
+		Version: c.Values["version"],
+		Company: c.Values["company"],
+		Website: c.Values["website"],
+		Source:  c.Values["source"],
+		License: c.Values["license"],
+	}
+	rec := &record{val: metadata}
+	rec.version, _ = semver.Parse(metadata.Version)
+	return entry{key: c.Key, rec: rec, score: c.Score}
+}
+
+//sortString is the canonical form of sort fields, kept in cursor to detect a changed sort order
+func sortString(fields []SortField) string {
+	var names []string
+	for _, field := range fields {
+		if field.Desc {
+			names = append(names, "-"+field.Name)
+		} else {
+			names = append(names, field.Name)
+		}
+	}
+	return strings.Join(names, ",")
+}
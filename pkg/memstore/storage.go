@@ -1,18 +1,51 @@
-//package memstore defines high level interface for in-memory storage operations
+//package memstore defines high level interface for storage operations.
+//It has an in-memory implementation and a durable one which keeps a write-ahead log
+//and snapshots on disk on top of the in-memory storage.
 package memstore
 
-import "../logger"
+import (
+	"../logger"
+	"context"
+	"errors"
+)
 
+//ErrNotFound is returned when there is no record with the given key
+var ErrNotFound = errors.New("record not found")
+
+//ErrConflict is returned when a record with the given key already exists
+var ErrConflict = errors.New("record already exists")
+
+//ErrInvalidQuery is returned when search parameters cannot be parsed
+var ErrInvalidQuery = errors.New("invalid query")
+
+//Storage defines the storage operations. Operations taking a context give up with the context error
+//if it is done before the storage is changed, or before the search is done.
 type Storage interface {
 
-	//Insert adds a key-value object into the in-memory storage
-	Insert(key string, val interface{})
+	//Insert adds a key-value object into the storage.
+	//Returns ErrConflict if there is already an object with the same key
+	Insert(ctx context.Context, key string, val interface{}) error
+
+	//Update replaces the object stored with the given key.
+	//Returns ErrNotFound if there is no such object
+	Update(ctx context.Context, key string, val interface{}) error
+
+	//Delete removes the object stored with the given key.
+	//Returns ErrNotFound if there is no such object
+	Delete(ctx context.Context, key string) error
 
 	//Read gets related object stored with the given key
 	Read(key string) interface{}
 
 	//ReadWithParams performs search using given parameters
-	ReadWithParams(params map[string][]string) []interface{}
+	ReadWithParams(params map[string][]string) ([]interface{}, error)
+
+	//Search performs search using given parameters and returns the requested page
+	//of the result ordered by the requested fields
+	Search(ctx context.Context, params map[string][]string, page PageRequest) (Page, error)
 
 	SetLogger(logger *logger.AsyncLogger)
+
+	//Close flushes whatever is pending and releases the underlying resources
+	Close() error
 }
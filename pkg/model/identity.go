@@ -0,0 +1,44 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+)
+
+//AppID returns the identity of the application, either the explicit ID
+//or a slug derived from the title
+func (m *Metadata) AppID() string {
+	if m.ID != "" {
+		return m.ID
+	}
+	return Slug(m.Title)
+}
+
+//Key returns the storage key of this version of the application
+func (m *Metadata) Key() string {
+	return Key(m.AppID(), m.Version)
+}
+
+//Key builds the storage key from application id and version
+func Key(appID string, version string) string {
+	return appID + "/" + version
+}
+
+//Slug converts the given title into an application id, e.g. "My valid app" becomes "my-valid-app".
+//Letters and digits are lowercased, any other run of characters becomes a single dash.
+func Slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -1,7 +1,10 @@
 //package model defines objects (models)
 package model
 
+//Metadata is the metadata of one version of an application.
+//An application is identified by ID, if ID is not given it is derived from the title.
 type Metadata struct {
+	ID          string           `yaml:"id,omitempty"`
 	Title       string           `yaml:"title"`
 	Version     string           `yaml:"version"`
 	Company     string           `yaml:"company"`
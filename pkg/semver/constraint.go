@@ -0,0 +1,238 @@
+package semver
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//ErrInvalidConstraint is returned when a version constraint cannot be parsed
+var ErrInvalidConstraint = errors.New("invalid version constraint")
+
+//comparator is a single operator and version pair a version is checked against
+type comparator struct {
+	op string
+	v  *Version
+}
+
+func (c comparator) check(v *Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+//Constraint is a set of version ranges. Comparators separated by space or comma must all match,
+//ranges separated by "||" are alternatives. Supported forms are:
+//
+//	1.2.3, =1.2.3, !=1.2.3          exact version
+//	>1.2.3, >=1.2, <2, <=1.4        comparisons, missing parts are filled as a range
+//	1.4, 1.4.x, 1.*                 any version in the range
+//	~1.4.2, ~1.4                    >=1.4.2 <1.5.0 and >=1.4.0 <1.5.0
+//	^1.2.3, ^0.2.3                  >=1.2.3 <2.0.0 and >=0.2.3 <0.3.0
+//
+//Pre-release versions only match a range which has a comparator with a pre-release
+//on the same major.minor.patch, so that ">=1.2.0" does not match 2.0.0-alpha.
+type Constraint struct {
+	sets [][]comparator
+}
+
+var rxOperatorSpace = regexp.MustCompile(`(>=|<=|!=|>|<|=|~|\^)\s+`)
+var rxComparator = regexp.MustCompile(`^(>=|<=|!=|>|<|=|~|\^)?(.*)$`)
+
+//ParseConstraint parses a version constraint like ">=1.2.0 <2.0.0" or "~1.4 || ^2.1".
+//Empty constraints and empty ranges are rejected.
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{}
+
+	for _, set := range strings.Split(s, "||") {
+		set = rxOperatorSpace.ReplaceAllString(strings.TrimSpace(set), "$1")
+
+		comparators := []comparator{}
+		for _, field := range strings.FieldsFunc(set, func(r rune) bool { return r == ' ' || r == ',' }) {
+			expanded, err := parseComparator(field)
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, expanded...)
+		}
+		//an empty range would match every version, e.g. "" or "1.2 ||"
+		if len(comparators) == 0 {
+			return nil, ErrInvalidConstraint
+		}
+		c.sets = append(c.sets, comparators)
+	}
+	return c, nil
+}
+
+//Check reports whether the version satisfies the constraint
+func (c *Constraint) Check(v *Version) bool {
+	for _, set := range c.sets {
+		if checkSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkSet(set []comparator, v *Version) bool {
+	for _, c := range set {
+		if !c.check(v) {
+			return false
+		}
+	}
+	if !v.IsPrerelease() {
+		return true
+	}
+	for _, c := range set {
+		if c.v.IsPrerelease() && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+//partial is a version where minor and patch may be missing or wildcards
+type partial struct {
+	parts int
+	v     Version
+}
+
+//parseComparator expands a single comparator into plain comparators
+func parseComparator(s string) ([]comparator, error) {
+	m := rxComparator.FindStringSubmatch(s)
+	op := m[1]
+	p, err := parsePartial(m[2])
+	if err != nil {
+		return nil, err
+	}
+	full := p.v
+
+	switch op {
+	case "", "=":
+		if p.parts == 3 {
+			return []comparator{{"=", &full}}, nil
+		}
+		return p.rangeTo(p.next(p.parts)), nil
+
+	case "!=":
+		if p.parts != 3 {
+			return nil, ErrInvalidConstraint
+		}
+		return []comparator{{"!=", &full}}, nil
+
+	case ">":
+		switch p.parts {
+		case 0:
+			return nil, ErrInvalidConstraint
+		case 3:
+			return []comparator{{">", &full}}, nil
+		}
+		return []comparator{{">=", p.next(p.parts)}}, nil
+
+	case ">=":
+		return []comparator{{">=", &full}}, nil
+
+	case "<":
+		if p.parts == 0 {
+			return nil, ErrInvalidConstraint
+		}
+		return []comparator{{"<", &full}}, nil
+
+	case "<=":
+		switch p.parts {
+		case 0:
+			return []comparator{}, nil
+		case 3:
+			return []comparator{{"<=", &full}}, nil
+		}
+		return []comparator{{"<", p.next(p.parts)}}, nil
+
+	case "~":
+		if p.parts == 3 {
+			return p.rangeTo(p.next(2)), nil
+		}
+		return p.rangeTo(p.next(p.parts)), nil
+
+	case "^":
+		switch {
+		case p.parts == 0:
+			return []comparator{}, nil
+		case p.v.Major > 0 || p.parts == 1:
+			return p.rangeTo(p.next(1)), nil
+		case p.v.Minor > 0 || p.parts == 2:
+			return p.rangeTo(p.next(2)), nil
+		}
+		return p.rangeTo(p.next(3)), nil
+	}
+	return nil, ErrInvalidConstraint
+}
+
+//rangeTo returns comparators for >= the partial version and < upper
+func (p partial) rangeTo(upper *Version) []comparator {
+	if p.parts == 0 {
+		return []comparator{}
+	}
+	lower := p.v
+	return []comparator{{">=", &lower}, {"<", upper}}
+}
+
+//next returns the lowest version which is greater than every version
+//having the same first n parts as the partial version
+func (p partial) next(n int) *Version {
+	switch n {
+	case 1:
+		return &Version{Major: p.v.Major + 1}
+	case 2:
+		return &Version{Major: p.v.Major, Minor: p.v.Minor + 1}
+	}
+	return &Version{Major: p.v.Major, Minor: p.v.Minor, Patch: p.v.Patch + 1}
+}
+
+//parsePartial parses versions like 1, 1.4, 1.4.x, * or a full version
+func parsePartial(s string) (partial, error) {
+	if v, err := Parse(s); err == nil {
+		return partial{parts: 3, v: *v}, nil
+	}
+
+	p := partial{}
+	if s == "" {
+		return p, nil
+	}
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		return p, ErrInvalidConstraint
+	}
+	for i, field := range fields {
+		if field == "x" || field == "X" || field == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil || (len(field) > 1 && field[0] == '0') {
+			return p, ErrInvalidConstraint
+		}
+		switch i {
+		case 0:
+			p.v.Major = n
+		case 1:
+			p.v.Minor = n
+		case 2:
+			p.v.Patch = n
+		}
+		p.parts = i + 1
+	}
+	return p, nil
+}
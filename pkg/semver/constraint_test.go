@@ -0,0 +1,71 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParseEmptyConstraint(t *testing.T) {
+	for _, s := range []string{"", "   ", "1.2 ||", "|| ^2.1", ","} {
+		if _, err := ParseConstraint(s); err != ErrInvalidConstraint {
+			t.Errorf("constraint %q: error %v, expected %v", s, err, ErrInvalidConstraint)
+		}
+	}
+	if _, err := ParseConstraint("~1.4 || ^2.1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4", "1.2.3-beta"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.2"}},
+		{"!=1.2.3", []string{"1.2.2", "1.2.4"}, []string{"1.2.3"}},
+		{">1.2.3", []string{"1.2.4", "2.0.0"}, []string{"1.2.3", "1.0.0"}},
+		{">=1.2", []string{"1.2.0", "1.3.5"}, []string{"1.1.9"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<2", []string{"1.9.9", "0.1.0"}, []string{"2.0.0", "2.1.0"}},
+		{"<=1.4", []string{"1.4.9", "1.0.0"}, []string{"1.5.0"}},
+		{"1.4", []string{"1.4.0", "1.4.9"}, []string{"1.3.9", "1.5.0"}},
+		{"1.4.x", []string{"1.4.0", "1.4.9"}, []string{"1.5.0"}},
+		{"1.*", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0"}},
+		{"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}},
+		{"~1.4", []string{"1.4.0", "1.4.9"}, []string{"1.3.9", "1.5.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.2.2", "0.3.0"}},
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.0", "2.0.0"}},
+		{">=1.2.0, <2.0.0", []string{"1.5.0"}, []string{"2.0.0"}},
+		{"~1.4 || ^2.1", []string{"1.4.5", "2.1.0", "2.9.0"}, []string{"1.5.0", "2.0.0", "3.0.0"}},
+		//pre-releases only match a comparator with a pre-release on the same version
+		{">=1.2.0", []string{"1.2.0", "2.0.0"}, []string{"2.0.0-alpha", "1.2.1-rc.1"}},
+		{">=1.2.0-beta <2.0.0", []string{"1.2.0-beta", "1.2.0-rc.1", "1.2.0"}, []string{"1.2.0-alpha", "1.3.0-beta"}},
+	}
+	for _, test := range tests {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("%s: %v", test.constraint, err)
+			continue
+		}
+		for _, s := range test.match {
+			if !c.Check(mustParse(t, s)) {
+				t.Errorf("%s does not match %s", test.constraint, s)
+			}
+		}
+		for _, s := range test.noMatch {
+			if c.Check(mustParse(t, s)) {
+				t.Errorf("%s matches %s", test.constraint, s)
+			}
+		}
+	}
+}
+
+func TestParseInvalidConstraint(t *testing.T) {
+	for _, s := range []string{"!=1.2", ">*", "<*", "1.2.3.4", ">=abc", "01.2", "^1.2.3-"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Errorf("invalid constraint %q is parsed", s)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+//Package semver implements parsing and ordering of semantic versions (https://semver.org/spec/v2.0.0.html)
+//and version constraints such as ">=1.2.0 <2.0.0", "~1.4" or "^1.2.3".
+package semver
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//ErrInvalidVersion is returned when a string is not a valid semantic version
+var ErrInvalidVersion = errors.New("invalid semantic version")
+
+//rxVersion is the regular expression suggested by the SemVer 2.0 specification
+var rxVersion = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+//Version is a parsed semantic version.
+//Build metadata is kept but it is ignored when versions are compared.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+//Parse parses a semantic version like 1.2.3, 1.2.3-beta.1 or 1.2.3+build.5
+func Parse(s string) (*Version, error) {
+	m := rxVersion.FindStringSubmatch(s)
+	if m == nil {
+		return nil, ErrInvalidVersion
+	}
+
+	v := &Version{}
+	var err error
+	if v.Major, err = strconv.ParseUint(m[1], 10, 64); err != nil {
+		return nil, ErrInvalidVersion
+	}
+	if v.Minor, err = strconv.ParseUint(m[2], 10, 64); err != nil {
+		return nil, ErrInvalidVersion
+	}
+	if v.Patch, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+		return nil, ErrInvalidVersion
+	}
+	if m[4] != "" {
+		v.Prerelease = strings.Split(m[4], ".")
+	}
+	if m[5] != "" {
+		v.Build = strings.Split(m[5], ".")
+	}
+	return v, nil
+}
+
+//IsValid reports whether the given string is a valid semantic version
+func IsValid(s string) bool {
+	return rxVersion.MatchString(s)
+}
+
+//IsPrerelease reports whether the version has a pre-release part like -alpha.1
+func (v *Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+//String returns the version in its canonical form
+func (v *Version) String() string {
+	s := strconv.FormatUint(v.Major, 10) + "." + strconv.FormatUint(v.Minor, 10) + "." + strconv.FormatUint(v.Patch, 10)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+//Compare returns -1, 0 or 1 if v is lower than, equal to or greater than o
+//following the precedence rules of the specification.
+func (v *Version) Compare(o *Version) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	//a version without pre-release has higher precedence than one with pre-release
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := compareIdentifier(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+//compareIdentifier compares pre-release identifiers. Numeric identifiers are compared numerically
+//and have lower precedence than alphanumeric ones which are compared in ASCII order.
+func compareIdentifier(a string, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareUint(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareUint(a uint64, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package semver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s       string
+		version *Version
+	}{
+		{"1.2.3", &Version{Major: 1, Minor: 2, Patch: 3}},
+		{"0.0.0", &Version{}},
+		{"1.0.0-alpha.1", &Version{Major: 1, Prerelease: []string{"alpha", "1"}}},
+		{"1.0.0+20130313144700", &Version{Major: 1, Build: []string{"20130313144700"}}},
+		{"1.0.0-beta+exp.sha.5114f85", &Version{Major: 1, Prerelease: []string{"beta"}, Build: []string{"exp", "sha", "5114f85"}}},
+		{"1.0.0-x-y-z.--", &Version{Major: 1, Prerelease: []string{"x-y-z", "--"}}},
+	}
+	for _, test := range tests {
+		v, err := Parse(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.version) {
+			t.Errorf("%s is parsed as %+v, expected %+v", test.s, v, test.version)
+		}
+		if v.String() != test.s {
+			t.Errorf("%s is printed as %s", test.s, v)
+		}
+	}
+
+	for _, s := range []string{"", "1", "1.2", "v1.2.3", "01.2.3", "1.02.3", "1.2.3-", "1.2.3-01", "1.2.3+", "1.2.3-alpha..1", "1.2.3.4"} {
+		if _, err := Parse(s); err != ErrInvalidVersion {
+			t.Errorf("%q: error %v, expected %v", s, err, ErrInvalidVersion)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	//precedence examples of the specification, each version is lower than the next one
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11",
+		"1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "1.10.0", "2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := mustParse(t, ordered[i]), mustParse(t, ordered[j])
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if c := a.Compare(b); c != expected {
+				t.Errorf("%s compared to %s is %d, expected %d", a, b, c, expected)
+			}
+		}
+	}
+
+	//build metadata is ignored
+	if c := mustParse(t, "1.0.0+build.1").Compare(mustParse(t, "1.0.0+build.2")); c != 0 {
+		t.Errorf("versions differing only in build metadata are compared as %d", c)
+	}
+}
+
+func mustParse(t *testing.T, s string) *Version {
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return v
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"../config"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//rateLimiter is a token bucket shared by all requests. Bucket holds at most burst tokens
+//and it is refilled with rate tokens per second, every request takes one token.
+//Zero rate means no limit. Limits can be changed at runtime by set.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{}
+	l.set(cfg)
+	return l
+}
+
+//set changes the limits, bucket starts full with the new burst
+func (l *rateLimiter) set(cfg config.RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = cfg.RequestsPerSecond
+	l.burst = float64(cfg.Burst)
+	if l.burst == 0 {
+		l.burst = math.Max(1, l.rate)
+	}
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+//allow takes a token if there is one, otherwise returns how long to wait for the next one
+func (l *rateLimiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+//withRateLimit middleware answers 429 Too Many Requests with Retry-After header when the rate limit is exceeded.
+//Health endpoint is not limited so that load balancers can always reach it.
+func (s *Server) withRateLimit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/health" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := s.limiter.allow(); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, "%s", "Rate limit exceeded")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -1,19 +1,36 @@
 package server
 
 import (
+	"../config"
 	"../context"
 	"../logger"
+	"../memstore"
 	"../model"
+	"../semver"
 	"../validator"
 	"../workpool"
 	"bytes"
+	gocontext "context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Paging limits of search results
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
 )
 
 //signature of the validation function which you can inject to handler to validate your request
@@ -22,22 +39,56 @@ type middleware func(h http.HandlerFunc) http.HandlerFunc
 
 //Shared dependencies, better to pass lots of parameters to handlers
 type Server struct {
-	Context  *context.AppContext
-	Routers  *mux.Router
-	jobQueue chan workpool.WorkRequest
+	Context     *context.AppContext
+	Routers     *mux.Router
+	admission   *workpool.Admission
+	jobs        *workpool.JobRegistry
+	metrics     *workpool.Metrics
+	deadLetters *workpool.DeadLetterQueue
+	log         *logger.AsyncLogger
+	limiter     *rateLimiter
 }
 
-//CreateServer creates and initialize a server instance and also creates handlers
-func CreateServer(ctx *context.AppContext, jobQueue chan workpool.WorkRequest) *Server {
+//CreateServer creates and initialize a server instance and also creates handlers.
+//Rate limit and validation rules are taken from the configuration and follow its reloads.
+func CreateServer(ctx *context.AppContext, admission *workpool.Admission, jobs *workpool.JobRegistry, metrics *workpool.Metrics,
+	deadLetters *workpool.DeadLetterQueue) *Server {
+	cfg := ctx.Config.Current()
 	server := &Server{
-		Context:  ctx,
-		Routers:  mux.NewRouter(),
-		jobQueue: jobQueue,
+		Context:     ctx,
+		Routers:     mux.NewRouter(),
+		admission:   admission,
+		jobs:        jobs,
+		metrics:     metrics,
+		deadLetters: deadLetters,
+		log:         ctx.Logger.Component("server"),
+		limiter:     newRateLimiter(cfg.Server.RateLimit),
 	}
+	validator.SetRules(validationRules(cfg.Validation))
+	ctx.Config.Subscribe(server.reload)
+
+	server.Routers.Use(server.withRequestID)
+	server.Routers.Use(server.withRateLimit)
 	server.routes()
 	return server
 }
 
+//reload applies the changed rate limit and validation rules
+func (s *Server) reload(old *config.Config, new *config.Config) {
+	if old.Server.RateLimit != new.Server.RateLimit {
+		s.limiter.set(new.Server.RateLimit)
+	}
+	validator.SetRules(validationRules(new.Validation))
+}
+
+func validationRules(cfg config.ValidationConfig) validator.Rules {
+	return validator.Rules{
+		AllowedLicenses:      cfg.AllowedLicenses,
+		MaxDescriptionLength: cfg.MaxDescriptionLength,
+		AllowPrerelease:      cfg.AllowPrerelease,
+	}
+}
+
 /**
 We have one resource which is app metadata. So conceptually  /apps can return all app metadata
 This means that, we can filter it with url query parameters. It also prevent us to define paths
@@ -49,21 +100,112 @@ to get application metadata, we are basically "filtering" which means we are try
 possible records. The best way to handle "filtering" is to define our GET method so that it can query data
 using url search parameters,
 
-If no search paramater passed via URL then it means server should return all data without filtering
-TODO: One point to pay attention here it is not feasible to return all data especially if data is huge
-TODO: so there should be additional solutions like "paging" in order to prevent performance issues
+If no search paramater passed via URL then it means server should return all data without filtering.
+Since it is not feasible to return all data at once especially if data is huge, results are paged.
+limit, cursor and sort parameters are used for paging and they are not search parameters:
+
+	limit   number of records in a page, DefaultPageLimit if not given and at most MaxPageLimit
+	sort    comma separated fields like sort=title,-version where - means descending order.
+	        Records are always ordered by id and version after the given fields so order is deterministic
+	cursor  opaque cursor of the next page, taken from the Link header of the previous page
+
+Total number of matching records is returned in X-Total-Count header and the next page in Link header.
+
+Every record is one version of an application. Application is identified by id field,
+if id is not given it is derived from the title, e.g. "My valid app" becomes "my-valid-app".
 
 POST - /api/v1/apps
 yaml or json payload inside body (application/json)
+Returns 202 Accepted with the job id and a Location header pointing to the job resource
+Returns 409 Conflict if the same version of the application already exists
+Returns 503 Service Unavailable with Retry-After header if the work pool is saturated
+
+POST - /api/v1/apps/{app}/versions
+Same as POST /api/v1/apps, id of the application in payload must be {app}
+
+Requests exceeding the configured rate limit (server.rateLimit) are answered 429 Too Many Requests
+with Retry-After header, health endpoint is not limited.
+
+GET - /api/v1/health
+Returns the queue depth and in-flight count of the work pool, 503 if the pool is saturated or shutting down
+so that load balancers can shed traffic
+
+GET - /api/v1/apps/{app}/versions
+Returns all versions of the application, 404 if there is no such application
+
+GET - /api/v1/apps/{app}/versions/latest
+Returns the highest non-prerelease version of the application
+
+GET - /api/v1/apps/{app}/versions/{version}
+Returns the given version of the application, 404 if there is no such record
+
+PUT - /api/v1/apps/{app}/versions/{version}
+Replaces the given version of the application with the validated payload in body
+
+PATCH - /api/v1/apps/{app}/versions/{version}
+Applies JSON Merge Patch (or same merge rules in yaml) in body to the given version of the application
+
+DELETE - /api/v1/apps/{app}/versions/{version}
+Removes the given version of the application
+
+GET - /api/v1/admin/log-levels
+Returns the minimum log level and levels of components (memstore, workpool, server)
+
+PUT - /api/v1/admin/log-levels
+Changes log levels at runtime, e.g. {"level": "WARNING", "components": {"memstore": "INFO"}}
+Missing level is left as it is, a component with empty level falls back to the minimum level.
+Admin endpoints need an admin API key, see below
+
+GET - /api/v1/admin/metrics
+Returns the metrics of the work pool: workers, busy workers, queue depth, processed, failed, retried,
+dead-lettered and panicked works, average wait and processing times and the scaling decisions of the autoscaler
+
+Works failing with transient errors (e.g. storage I/O errors) are retried with exponential backoff, works still
+failing after the last attempt are moved to the dead-letter queue and their job status becomes dead-lettered.
+
+GET - /api/v1/admin/dead-letters
+Returns the dead-lettered works, oldest first, with the number of attempts and the last error
+
+GET - /api/v1/admin/dead-letters/{id}
+Returns the dead-lettered work of the job with the given id
+
+POST - /api/v1/admin/dead-letters/{id}/replay
+Submits the work again with the same job id, 202 Accepted with Location of the job or 503 if the pool is saturated
+
+DELETE - /api/v1/admin/dead-letters/{id}
+Discards the work, its job fails
+
+All admin endpoints need an admin API key (X-API-Key header) configured in server.adminKeys, since dead letters
+have the payloads of the failed works. Requests without API key are answered 401 Unauthorized
+and requests with another key 403 Forbidden.
+
+PUT, PATCH and DELETE are processed by the work pool like POST and answer 202 Accepted with the job id
+
+GET - /api/v1/jobs/{id}
+Returns the status of the job created by a POST (queued, running, succeeded, failed, dead-lettered or cancelled)
+
+DELETE - /api/v1/jobs/{id}
+Cancels a queued or running job and answers 202 Accepted with its status, 409 Conflict if it has already finished.
+Queued job is cancelled at once, running job is cancelled as soon as storage gives up.
+
+Jobs are processed within workpool.jobTimeout, X-Job-Timeout header (e.g. 5s) of the request asks for a shorter one
+
+Jobs wait in the normal lane of the work queue, X-Priority header asks for high, normal or bulk lane.
+High lane needs an API key (X-API-Key header) configured with high priority in workpool.priority.apiKeys,
+requests of such keys use the lane of the key by default. Higher priority than allowed is answered 403 Forbidden.
 
 GET - /api/v1/apps
 Returns all records
 
 GET - /api/v1/apps?version=1.0.0
-Returns the record with version 1.0.0
+Returns the records with version 1.0.0 of all applications
+
+GET - /api/v1/apps?version=>=1.2.0 <2.0.0
+GET - /api/v1/apps?version=~1.4
+Returns the records with versions satisfying the semantic version constraint
 
-GET - /api/v1/apps?version=1.0.0&title=my%20app
-Returns the record with version 1.0.0 if exists.Does not check other parameters as version number is unique.
+GET - /api/v1/apps?id=my-app&version=1.0.0&title=my%20app
+Returns the record with version 1.0.0 of my-app if exists.Does not check other parameters as id and version are unique.
 
 GET - /api/v1/apps?company=mycompany.com&title=my%20app
 Returns record(s) with company name "mycompany.com" and title **contains** "my app"
@@ -71,6 +213,11 @@ Returns record(s) with company name "mycompany.com" and title **contains** "my a
 GET - /api/v1/apps?description=latest
 Returns record(s) with description **contains** "latest"
 
+GET - /api/v1/apps?q=mobile payments
+GET - /api/v1/apps?q="payment gateway" mobile
+Full-text search over title and description. Returns records matching any of the terms ordered by relevance,
+if there are quoted phrases then records must contain them. Each item has the record and its relevance score.
+
 GET - /api/v1/apps?maintainers.name=Bill&maintainers.name=Joe
 Returns record(s) which have/has maintainers name "Bill" and "Joe"
 
@@ -94,68 +241,687 @@ func (s *Server) routes() {
 	s.Routers.HandleFunc("/api/v1/apps", s.Chain(s.searchAppMetadataHandler,
 		s.withLog())).Methods("GET")
 
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions", s.Chain(s.createAppMetadataHandler,
+		s.withValidation(validator.ValidateRequest),
+		s.withLog())).Methods("POST")
+
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions", s.Chain(s.listAppVersionsHandler,
+		s.withLog())).Methods("GET")
+
+	//latest must be registered before {version} so that it is not taken as a version
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions/latest", s.Chain(s.latestAppVersionHandler,
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions/{version}", s.Chain(s.getAppMetadataHandler,
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions/{version}", s.Chain(s.updateAppMetadataHandler,
+		s.withValidation(validator.ValidateRequest),
+		s.withLog())).Methods("PUT")
+
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions/{version}", s.Chain(s.patchAppMetadataHandler,
+		s.withLog())).Methods("PATCH")
+
+	s.Routers.HandleFunc("/api/v1/apps/{app}/versions/{version}", s.Chain(s.deleteAppMetadataHandler,
+		s.withLog())).Methods("DELETE")
+
+	s.Routers.HandleFunc("/api/v1/jobs/{id}", s.Chain(s.getJobHandler,
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/jobs/{id}", s.Chain(s.cancelJobHandler,
+		s.withLog())).Methods("DELETE")
+
+	s.Routers.HandleFunc("/api/v1/admin/log-levels", s.Chain(s.getLogLevelsHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/admin/log-levels", s.Chain(s.setLogLevelsHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("PUT")
+
+	s.Routers.HandleFunc("/api/v1/admin/metrics", s.Chain(s.metricsHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/admin/dead-letters", s.Chain(s.listDeadLettersHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/admin/dead-letters/{id}", s.Chain(s.getDeadLetterHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("GET")
+
+	s.Routers.HandleFunc("/api/v1/admin/dead-letters/{id}/replay", s.Chain(s.replayDeadLetterHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("POST")
+
+	s.Routers.HandleFunc("/api/v1/admin/dead-letters/{id}", s.Chain(s.discardDeadLetterHandler,
+		s.withAdmin(),
+		s.withLog())).Methods("DELETE")
+
+	s.Routers.HandleFunc("/api/v1/health", s.healthHandler).Methods("GET")
+
 }
 
 //searchAppMetadataHandler returns the related records matching url query parameters
-//if url query params are empty then returns all records
+//if url query params are empty then returns all records page by page
 //Default content type is yaml. However, if client explicetly requires json format
 //then server returns the response in json
 func (s *Server) searchAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
 
 	queryStr := r.URL.Query() //map[string][]string
-	result := s.Context.Storage.ReadWithParams(queryStr)
-	if r.Header.Get("Accept") == "application/json" {
-		s.Context.Logger.Log(logger.INFO, "<-- appliation/json has been requested by client")
-		json.NewEncoder(w).Encode(result)
-	} else {
-		yaml.NewEncoder(w).Encode(result)
+	page, err := pageRequest(queryStr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
 	}
+
+	result, err := s.Context.Storage.Search(r.Context(), queryStr, page)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	links := []string{pageLink(r, "", "first")}
+	if result.NextCursor != "" {
+		links = append(links, pageLink(r, result.NextCursor, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	s.writeResponse(w, r, http.StatusOK, result.Items)
+}
+
+//pageRequest takes paging parameters out of url query parameters
+//so that remaining ones are search parameters
+func pageRequest(queryStr url.Values) (memstore.PageRequest, error) {
+	page := memstore.PageRequest{
+		Limit:  DefaultPageLimit,
+		Cursor: queryStr.Get("cursor"),
+	}
+
+	if limit := queryStr.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > MaxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", MaxPageLimit)
+		}
+		page.Limit = n
+	}
+
+	sortFields, err := memstore.ParseSort(queryStr.Get("sort"))
+	if err != nil {
+		return page, errors.New("sort must be a comma separated list of id, title, version, company, website, source, license and score")
+	}
+	page.Sort = sortFields
+
+	queryStr.Del("limit")
+	queryStr.Del("cursor")
+	queryStr.Del("sort")
+	return page, nil
+}
+
+//pageLink builds a Link header value for the same search with the given cursor
+func pageLink(r *http.Request, cursor string, rel string) string {
+	query := r.URL.Query()
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	link := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return "<" + link.String() + ">; rel=\"" + rel + "\""
 }
 
-//searchAppMetadataHandler creates the appliation metadata sent via body payload
+//createAppMetadataHandler creates the appliation metadata sent via body payload
 //supports both yaml and json payloads. It uses work queues in order to process
 //POST requests. So whenever it receives a POST request, it creates a work item and
-//and pass it to the work queue without waiting. Uses a buffered channel in order not
-//to block handler.
+//and pass it to the work queue through admission. If the pool is saturated and the work
+//cannot be queued within the enqueue timeout, handler answers 503 with Retry-After header.
+//Handler answers with 202 Accepted and the job id, so that client can follow
+//the progress of the work using the job resource given in Location header.
 func (s *Server) createAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.readMetadata(w, r)
+	if !ok {
+		return
+	}
+	if app, ok := mux.Vars(r)["app"]; ok && m.AppID() != app {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Application id %s in payload does not match %s in path", m.AppID(), app)
+		return
+	}
+
+	//key is claimed before storage is checked, so concurrent requests of the same version cannot both be accepted.
+	//Claim is released when the job finishes, its record is in storage then if it has succeeded.
+	key := m.Key()
+	id := uuid.New()
+	if !s.jobs.Claim(key, id) || s.Context.Storage.Read(key) != nil {
+		s.jobs.Unclaim(id)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Version %s of application %s already exists", m.Version, m.AppID())
+		return
+	}
+
+	s.submit(w, r, workpool.WorkRequest{
+		ID:      id,
+		Op:      workpool.OpInsert,
+		Key:     key,
+		Payload: m,
+	})
+	if _, ok := s.jobs.Get(id); !ok {
+		//job is not submitted
+		s.jobs.Unclaim(id)
+	}
+}
+
+//listAppVersionsHandler returns all versions of the application given in path
+//ordered by semantic version precedence
+func (s *Server) listAppVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	app := mux.Vars(r)["app"]
+
+	result, _ := s.Context.Storage.ReadWithParams(map[string][]string{"id": {app}})
+	if len(result) == 0 {
+		s.notFound(w, app)
+		return
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return compareVersions(result[i], result[j]) < 0
+	})
+	s.writeResponse(w, r, http.StatusOK, result)
+}
+
+//latestAppVersionHandler returns the highest non-prerelease version of the application given in path
+func (s *Server) latestAppVersionHandler(w http.ResponseWriter, r *http.Request) {
+	app := mux.Vars(r)["app"]
+
+	result, _ := s.Context.Storage.ReadWithParams(map[string][]string{"id": {app}})
+
+	var latest interface{}
+	var latestVersion *semver.Version
+	for _, val := range result {
+		metadata, ok := val.(model.Metadata)
+		if !ok {
+			continue
+		}
+		v, err := semver.Parse(metadata.Version)
+		if err != nil || v.IsPrerelease() {
+			continue
+		}
+		if latestVersion == nil || v.Compare(latestVersion) > 0 {
+			latest, latestVersion = val, v
+		}
+	}
+	if latest == nil {
+		s.notFound(w, app+"/latest")
+		return
+	}
+	s.writeResponse(w, r, http.StatusOK, latest)
+}
+
+//compareVersions compares versions of two metadata records by semantic version precedence.
+//Versions which are not semantic versions are compared as strings after the valid ones.
+func compareVersions(a interface{}, b interface{}) int {
+	ma, _ := a.(model.Metadata)
+	mb, _ := b.(model.Metadata)
+	va, errA := semver.Parse(ma.Version)
+	vb, errB := semver.Parse(mb.Version)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(ma.Version, mb.Version)
+}
+
+//getAppMetadataHandler returns the version of the application given in path
+func (s *Server) getAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	key := recordKey(r)
+
+	result := s.Context.Storage.Read(key)
+	if result == nil {
+		s.notFound(w, key)
+		return
+	}
+	s.writeResponse(w, r, http.StatusOK, result)
+}
+
+//updateAppMetadataHandler replaces the version of the application given in path with the body payload.
+//Id and version inside the payload must be same as the ones in path, since they are the key of the record.
+//Replacement is processed by work pool in the same way as inserts.
+func (s *Server) updateAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	key := recordKey(r)
+
+	m, ok := s.readMetadata(w, r)
+	if !ok {
+		return
+	}
+	if m.Key() != key {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Application %s in payload does not match %s in path", m.Key(), key)
+		return
+	}
+	if s.Context.Storage.Read(key) == nil {
+		s.notFound(w, key)
+		return
+	}
+
+	s.submit(w, r, workpool.WorkRequest{
+		Op:      workpool.OpUpdate,
+		Key:     key,
+		Payload: m,
+	})
+}
+
+//patchAppMetadataHandler applies the merge patch (JSON Merge Patch or its yaml equivalent) in body
+//to the version of the application given in path. Patch is applied by the worker to the state of the record
+//at the time the work is processed and the result is validated before it is stored.
+func (s *Server) patchAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	key := recordKey(r)
+
+	var patch interface{}
+	bodyBytes := readBody(r)
+	if err := yaml.Unmarshal(bodyBytes, &patch); err != nil {
+		s.logger(r).LogFields(logger.ERROR, "Patch cannot be parsed", logger.Err(err))
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+	if s.Context.Storage.Read(key) == nil {
+		s.notFound(w, key)
+		return
+	}
+
+	s.submit(w, r, workpool.WorkRequest{
+		Op:    workpool.OpPatch,
+		Key:   key,
+		Patch: bodyBytes,
+	})
+}
+
+//deleteAppMetadataHandler removes the version of the application given in path
+func (s *Server) deleteAppMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	key := recordKey(r)
+
+	if s.Context.Storage.Read(key) == nil {
+		s.notFound(w, key)
+		return
+	}
+
+	s.submit(w, r, workpool.WorkRequest{
+		Op:  workpool.OpDelete,
+		Key: key,
+	})
+}
+
+//recordKey builds the storage key from application id and version in path
+func recordKey(r *http.Request) string {
+	vars := mux.Vars(r)
+	return model.Key(vars["app"], vars["version"])
+}
+
+//readMetadata parses the body payload into metadata.
+//If body cannot be parsed, it answers 400 and returns false.
+func (s *Server) readMetadata(w http.ResponseWriter, r *http.Request) (model.Metadata, bool) {
+	bodyString := string(readBody(r))
+
+	s.logger(r).Log(logger.INFO, "Request body --> ", bodyString)
+
+	var m = model.Metadata{}
+	err := yaml.Unmarshal([]byte(bodyString), &m)
+	if err != nil {
+		s.logger(r).LogFields(logger.ERROR, "Request body cannot be parsed", logger.Err(err))
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return m, false
+	}
+	return m, true
+}
+
+//submit registers the job and passes it to the work queue through admission.
+//Answers 202 Accepted with the job status and Location of the job resource,
+//or 503 with Retry-After header if the pool is saturated. Job gets a new id unless it has one.
+//Job context keeps the values of request context but it is not canceled when the response is written.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, job workpool.WorkRequest) {
+	timeout, err := s.jobTimeout(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+	priority, err := s.priority(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if err == errPriorityNotAllowed {
+			status = http.StatusForbidden
+		}
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+	if job.ID == uuid.Nil {
+		job.ID = uuid.New()
+	}
+	job.RequestID = requestID(r)
+	job.Timeout = timeout
+	job.Priority = priority
+
+	job = job.WithContext(s.jobs.Add(workpool.Detach(r.Context()), job.ID))
+	if err := s.admission.Submit(job); err != nil {
+		s.jobs.Remove(job.ID)
+		s.logger(r).LogFields(logger.WARNING, "Work rejected", logger.F("job", job.ID), logger.F("priority", job.Priority), logger.Err(err))
+		w.Header().Set("Retry-After", strconv.Itoa(s.admission.RetryAfter()))
+		w.Header().Set("X-Queue-Depth", strconv.Itoa(s.admission.QueueDepth()))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+
+	status, _ := s.jobs.Get(job.ID)
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID.String())
+	w.Header().Set("X-Queue-Depth", strconv.Itoa(s.admission.QueueDepth()))
+	s.writeResponse(w, r, http.StatusAccepted, status)
+}
+
+//jobTimeout returns the timeout asked by X-Job-Timeout header, zero means the configured workpool.jobTimeout.
+//Timeout cannot be longer than the configured one.
+func (s *Server) jobTimeout(r *http.Request) (time.Duration, error) {
+	header := r.Header.Get("X-Job-Timeout")
+	if header == "" {
+		return 0, nil
+	}
+	max := s.Context.Config.Current().WorkPool.JobTimeout
+	timeout, err := time.ParseDuration(header)
+	if err != nil || timeout <= 0 || timeout > max {
+		return 0, fmt.Errorf("X-Job-Timeout must be a duration like 5s, at most %s", max)
+	}
+	return timeout, nil
+}
+
+//errPriorityNotAllowed is returned when a request asks for a higher priority than its API key allows
+var errPriorityNotAllowed = errors.New("X-Priority is higher than the API key allows")
+
+//priority returns the lane of the work queue asked by X-Priority header.
+//Requests with an API key configured in workpool.priority.apiKeys use the lane of the key unless they ask for a lower one,
+//other requests use normal lane and can ask for bulk lane.
+func (s *Server) priority(r *http.Request) (workpool.Priority, error) {
+	allowed := workpool.PriorityNormal
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if lane, ok := s.Context.Config.Current().WorkPool.Priority.APIKeys[key]; ok {
+			allowed, _ = workpool.ParsePriority(lane)
+		}
+	}
+
+	header := r.Header.Get("X-Priority")
+	if header == "" {
+		return allowed, nil
+	}
+	priority, err := workpool.ParsePriority(header)
+	if err != nil {
+		return priority, err
+	}
+	if priority.Above(allowed) {
+		return priority, errPriorityNotAllowed
+	}
+	return priority, nil
+}
+
+//notFound answers 404 for the given record key
+func (s *Server) notFound(w http.ResponseWriter, key string) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "Record %s not found", key)
+}
+
+//readBody reads the request body and restores it so that it can be read again
+func readBody(r *http.Request) []byte {
 	var bodyBytes []byte
 	if r.Body != nil {
 		bodyBytes, _ = ioutil.ReadAll(r.Body)
 	}
 	// Restore the io.ReadCloser to its original state
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-	bodyString := string(bodyBytes)
+	return bodyBytes
+}
 
-	s.Context.Logger.Log(logger.INFO, "Request body --> ", bodyString)
+//poolHealth is the response of health endpoint
+type poolHealth struct {
+	Status        string `json:"status" yaml:"status"`
+	QueueDepth    int    `json:"queueDepth" yaml:"queueDepth"`
+	QueueCapacity int    `json:"queueCapacity" yaml:"queueCapacity"`
+	InFlight      int    `json:"inFlight" yaml:"inFlight"`
+	MaxInFlight   int    `json:"maxInFlight" yaml:"maxInFlight"`
+}
 
-	var m = model.Metadata{}
-	err := yaml.Unmarshal([]byte(bodyString), &m)
+//healthHandler reports the load of the work pool.
+//Answers 503 if the pool is saturated so that load balancers can shed traffic.
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	health := poolHealth{
+		Status:        "ok",
+		QueueDepth:    s.admission.QueueDepth(),
+		QueueCapacity: s.admission.QueueCapacity(),
+		InFlight:      s.admission.InFlight(),
+		MaxInFlight:   s.admission.MaxInFlight(),
+	}
+	status := http.StatusOK
+	if s.admission.Closed() {
+		health.Status = "shutting-down"
+		status = http.StatusServiceUnavailable
+	} else if s.admission.Saturated() {
+		health.Status = "saturated"
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", strconv.Itoa(s.admission.RetryAfter()))
+	}
+	s.writeResponse(w, r, status, health)
+}
+
+//metricsHandler returns the metrics of the work pool
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := s.metrics.Snapshot()
+	metrics.QueueDepth = s.admission.QueueDepth()
+	metrics.LaneDepths = make(map[string]int)
+	for _, priority := range workpool.Priorities {
+		metrics.LaneDepths[priority.String()] = s.admission.LaneDepth(priority)
+	}
+	metrics.InFlight = s.admission.InFlight()
+	metrics.KeyWaiting = s.admission.KeyWaiting()
+	metrics.DeadLetters = s.deadLetters.Len()
+	s.writeResponse(w, r, http.StatusOK, metrics)
+}
+
+//listDeadLettersHandler returns all dead-lettered works
+func (s *Server) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeResponse(w, r, http.StatusOK, s.deadLetters.List())
+}
+
+//getDeadLetterHandler returns the dead-lettered work with the job id given in path
+func (s *Server) getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	letter, ok := s.deadLetters.Get(id)
+	if !ok {
+		s.deadLetterNotFound(w, id)
+		return
+	}
+	s.writeResponse(w, r, http.StatusOK, letter)
+}
+
+//replayDeadLetterHandler submits the dead-lettered work again.
+//Work stays in the dead-letter queue if it cannot be admitted.
+func (s *Server) replayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	found, err := s.deadLetters.Replay(id, s.admission, s.jobs)
+	if !found {
+		s.deadLetterNotFound(w, id)
+		return
+	}
+	if err != nil {
+		s.logger(r).LogFields(logger.WARNING, "Dead-lettered work could not be replayed", logger.F("job", id), logger.Err(err))
+		w.Header().Set("Retry-After", strconv.Itoa(s.admission.RetryAfter()))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%s", err.Error())
+		return
+	}
+
+	s.logger(r).LogFields(logger.WARNING, "Dead-lettered work has been replayed", logger.F("job", id))
+	status, _ := s.jobs.Get(id)
+	w.Header().Set("Location", "/api/v1/jobs/"+id.String())
+	s.writeResponse(w, r, http.StatusAccepted, status)
+}
+
+//discardDeadLetterHandler removes the dead-lettered work, its job is marked as failed
+func (s *Server) discardDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.deadLetters.Remove(id); !ok {
+		s.deadLetterNotFound(w, id)
+		return
+	}
+	s.jobs.Fail(id, workpool.ErrDiscarded)
+	s.logger(r).LogFields(logger.WARNING, "Dead-lettered work has been discarded", logger.F("job", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//jobID parses the job id in path, answers 400 if it is not valid
+func (s *Server) jobID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
 	if err != nil {
-		s.Context.Logger.Log(logger.ERROR, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err.Error())
+		return id, false
+	}
+	return id, true
+}
+
+func (s *Server) deadLetterNotFound(w http.ResponseWriter, id uuid.UUID) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "Dead letter %s not found", id.String())
+}
+
+//getLogLevelsHandler returns the minimum log level and levels of components
+func (s *Server) getLogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeResponse(w, r, http.StatusOK, s.Context.Logger.Levels())
+}
+
+//setLogLevelsHandler changes the levels in body. All levels are checked before any of them is applied.
+func (s *Server) setLogLevelsHandler(w http.ResponseWriter, r *http.Request) {
+	var levels logger.Levels
+	if err := yaml.Unmarshal(readBody(r), &levels); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "%s", err.Error())
 		return
 	}
 
-	job := workpool.WorkRequest{
-		Payload: m,
-		ID:      uuid.New(),
+	var min logger.LogLevel
+	var err error
+	if levels.Level != "" {
+		if min, err = logger.ParseLevel(levels.Level); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s %s", err.Error(), levels.Level)
+			return
+		}
+	}
+	components := make(map[string]logger.LogLevel)
+	for component, name := range levels.Components {
+		if name == "" {
+			continue
+		}
+		if components[component], err = logger.ParseLevel(name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s %s", err.Error(), name)
+			return
+		}
+	}
+
+	if levels.Level != "" {
+		s.Context.Logger.SetLevel(min)
+	}
+	for component, name := range levels.Components {
+		if name == "" {
+			s.Context.Logger.ResetComponentLevel(component)
+		} else {
+			s.Context.Logger.SetComponentLevel(component, components[component])
+		}
+	}
+	s.logger(r).LogFields(logger.WARNING, "Log levels have been changed", logger.F("level", levels.Level), logger.F("components", levels.Components))
+	s.writeResponse(w, r, http.StatusOK, s.Context.Logger.Levels())
+}
+
+//getJobHandler returns the status of the job with the id given in path
+func (s *Server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Job %s not found", id.String())
+		return
+	}
+	s.writeResponse(w, r, http.StatusOK, job)
+}
+
+//cancelJobHandler cancels the job with the id given in path
+func (s *Server) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.jobID(w, r)
+	if !ok {
+		return
 	}
-	s.jobQueue <- job
 
+	job, err := s.jobs.Cancel(id)
+	switch err {
+	case nil:
+	case workpool.ErrJobNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Job %s not found", id.String())
+		return
+	case workpool.ErrJobFinished:
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Job %s has already %s", id.String(), job.Status)
+		return
+	}
+
+	s.logger(r).LogFields(logger.WARNING, "Job has been cancelled", logger.F("job", id), logger.F("status", job.Status))
+	w.Header().Set("Location", "/api/v1/jobs/"+id.String())
+	s.writeResponse(w, r, http.StatusAccepted, job)
+}
+
+//writeResponse encodes the result with the given status code.
+//Default content type is yaml. However, if client explicetly requires json format
+//then server returns the response in json
+func (s *Server) writeResponse(w http.ResponseWriter, r *http.Request, status int, result interface{}) {
+	if r.Header.Get("Accept") == "application/json" {
+		s.logger(r).Log(logger.INFO, "<-- appliation/json has been requested by client")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	} else {
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.WriteHeader(status)
+		yaml.NewEncoder(w).Encode(result)
+	}
 }
 
 //withValidation middleware performs validation check on request body
 func (s *Server) withValidation(validator validatorFunc) middleware {
 
-	s.Context.Logger.Log(logger.INFO, "withValidation called")
+	s.log.Log(logger.INFO, "withValidation called")
 
 	return func(h http.HandlerFunc) http.HandlerFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if isValid, errorStr := validator(r); !isValid {
-				s.Context.Logger.Log(logger.ERROR, "Request is not valid - ", errorStr)
+				s.logger(r).LogFields(logger.ERROR, "Request is not valid", logger.F("reason", errorStr))
 				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, "%s", "Request is not valid -", errorStr)
+				fmt.Fprintf(w, "%s %s", "Request is not valid -", errorStr)
 				return
 			} else {
 				h(w, r)
@@ -164,24 +930,93 @@ func (s *Server) withValidation(validator validatorFunc) middleware {
 	}
 }
 
+//withAdmin middleware lets only the requests with an admin API key, one of server.adminKeys, reach the handler.
+//Requests without X-API-Key header are answered 401 Unauthorized, requests with another key 403 Forbidden.
+func (s *Server) withAdmin() middleware {
+
+	s.log.Log(logger.INFO, "withAdmin called")
+
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if key == "" {
+				s.logger(r).LogFields(logger.WARNING, "Admin request without API key", logger.F("path", r.URL.Path))
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(w, "%s", "X-API-Key header is required")
+				return
+			}
+			if !s.isAdminKey(key) {
+				s.logger(r).LogFields(logger.WARNING, "Admin request with a non-admin API key", logger.F("path", r.URL.Path))
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, "%s", "API key is not allowed to use admin endpoints")
+				return
+			}
+			h(w, r)
+		})
+	}
+}
+
+//isAdminKey reports whether the API key is one of server.adminKeys, keys are compared in constant time
+func (s *Server) isAdminKey(key string) bool {
+	admin := false
+	for _, adminKey := range s.Context.Config.Current().Server.AdminKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			admin = true
+		}
+	}
+	return admin
+}
+
 //withLog middleware logs messages for the handler.
 func (s *Server) withLog() middleware {
 
-	s.Context.Logger.Log(logger.INFO, "withLog called")
+	s.log.Log(logger.INFO, "withLog called")
 
 	return func(h http.HandlerFunc) http.HandlerFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-			s.Context.Logger.Log(logger.INFO, "<--", r.Method)
-			s.Context.Logger.Log(logger.INFO, "<--", r.Header.Get("Accept"))
-			s.Context.Logger.Log(logger.INFO, "<--", r.URL.Path)
-			s.Context.Logger.Log(logger.INFO, "<--", r.URL.RawQuery)
+			s.logger(r).LogFields(logger.INFO, "<--",
+				logger.F("method", r.Method),
+				logger.F("path", r.URL.Path),
+				logger.F("query", r.URL.RawQuery),
+				logger.F("accept", r.Header.Get("Accept")))
 			h(w, r)
 		})
 
 	}
 }
 
+//requestIDKey is the key of the request id in request context
+type requestIDKey struct{}
+
+//withRequestID middleware assigns an id to every request so that log messages of a request can be correlated.
+//Id given by client in X-Request-ID header is kept, otherwise a new one is generated.
+//Id is returned in X-Request-ID response header and passed to the jobs created by the request.
+func (s *Server) withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(gocontext.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+//requestID returns the id assigned to the request by withRequestID
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+//logger returns the server logger which adds request id to the messages
+func (s *Server) logger(r *http.Request) *logger.AsyncLogger {
+	if id := requestID(r); id != "" {
+		return s.log.With(logger.RequestID(id))
+	}
+	return s.log
+}
+
 //Chain function chains the handlers with middleware functions.
 func (s *Server) Chain(h http.HandlerFunc, m ...middleware) http.HandlerFunc {
 
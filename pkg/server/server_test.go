@@ -0,0 +1,173 @@
+package server
+
+import (
+	"../config"
+	"../context"
+	"../logger"
+	"../memstore"
+	"../model"
+	"../workpool"
+	gocontext "context"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testServer creates a server whose admin API key is admin-key, its work pool is not started
+func testServer(t *testing.T) *Server {
+	log := logger.CreateAsyncLogger()
+	for level := range logger.LogLevelStr {
+		log.SetSink(logger.LogLevel(level), ioutil.Discard)
+	}
+	c := config.Default()
+	c.Server.AdminKeys = []string{"admin-key"}
+	ctx := &context.AppContext{Storage: memstore.CreateInMemDB(), Logger: log, Config: config.NewStore(c, nil, log)}
+	return CreateServer(ctx, workpool.NewAdmission(10, 100, time.Second), workpool.NewJobRegistry(), workpool.NewMetrics(),
+		workpool.NewDeadLetterQueue(10))
+}
+
+//serve sends the request with the given API key to the server and returns the response
+func serve(s *Server, method string, target string, body string, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		r.Header.Set("X-API-Key", apiKey)
+	}
+	w := httptest.NewRecorder()
+	s.Routers.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	s := testServer(t)
+	const id = "5b0c1d4e-8f2a-4c61-9d3e-2a7f9b1c0e55"
+	//status is the answer to an admin, no dead letter is found
+	endpoints := []struct {
+		method, target string
+		status         int
+	}{
+		{"POST", "/api/v1/admin/dead-letters/" + id + "/replay", http.StatusNotFound},
+		{"DELETE", "/api/v1/admin/dead-letters/" + id, http.StatusNotFound},
+		{"PUT", "/api/v1/admin/log-levels", http.StatusOK},
+		{"GET", "/api/v1/admin/log-levels", http.StatusOK},
+		{"GET", "/api/v1/admin/metrics", http.StatusOK},
+		{"GET", "/api/v1/admin/dead-letters", http.StatusOK},
+		{"GET", "/api/v1/admin/dead-letters/" + id, http.StatusNotFound},
+	}
+	for _, endpoint := range endpoints {
+		for _, test := range []struct {
+			apiKey string
+			status int
+		}{
+			{"", http.StatusUnauthorized},
+			{"other-key", http.StatusForbidden},
+			{"admin-key", endpoint.status},
+		} {
+			w := serve(s, endpoint.method, endpoint.target, `{"level": "INFO"}`, test.apiKey)
+			if w.Code != test.status {
+				t.Errorf("%s %s with API key %q: status %d, expected %d", endpoint.method, endpoint.target, test.apiKey, w.Code, test.status)
+			}
+		}
+	}
+}
+
+const validMetadata = `{"title": "My valid app", "version": "1.0.8", "company": "Ecaglar Inc.", "website": "https://ecaglar.net",
+"source": "https://github.com/levye/repo", "license": "Apache-2.1",
+"maintainers": [{"name": "Firstname Lastname", "email": "emre@hotmail.com"}], "description": "blob of markdown"}`
+
+func TestConcurrentCreateOfSameVersion(t *testing.T) {
+	s := testServer(t)
+	const requests = 20
+
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- serve(s, "POST", "/api/v1/apps", validMetadata, "").Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusAccepted] != 1 || counts[http.StatusConflict] != requests-1 {
+		t.Fatalf("concurrent requests of the same version are answered %v, expected one 202 and the rest 409", counts)
+	}
+}
+
+func TestSearchWithEmptyVersion(t *testing.T) {
+	s := testServer(t)
+	for _, version := range []string{"", "%20%20"} {
+		if w := serve(s, "GET", "/api/v1/apps?version="+version, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("version=%s: status %d, expected %d", version, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestFullTextSearchScore(t *testing.T) {
+	s := testServer(t)
+	for _, m := range []model.Metadata{
+		{Title: "Payment gateway", Version: "1.0.0", Description: "Accepts cards"},
+		{Title: "Ledger", Version: "1.0.0", Description: "Imports payments"},
+		{Title: "Calendar", Version: "1.0.0", Description: "Meetings"},
+	} {
+		if err := s.Context.Storage.Insert(gocontext.Background(), m.Key(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := serve(s, "GET", "/api/v1/apps?q=payment", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, expected %d", w.Code, http.StatusOK)
+	}
+	var hits []struct {
+		Score float64 `yaml:"score"`
+		App   struct {
+			Title string `yaml:"title"`
+		} `yaml:"app"`
+	}
+	if err := yaml.Unmarshal(w.Body.Bytes(), &hits); err != nil {
+		t.Fatal(err)
+	}
+	//title matches are more relevant than description matches
+	if len(hits) != 2 || hits[0].App.Title != "Payment gateway" || hits[1].App.Title != "Ledger" {
+		t.Fatalf("q=payment returns %+v, expected Payment gateway and then Ledger", hits)
+	}
+	if hits[0].Score <= hits[1].Score || hits[1].Score <= 0 {
+		t.Fatalf("scores are %f and %f, expected positive and descending", hits[0].Score, hits[1].Score)
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	s := testServer(t)
+	for _, version := range []string{"1.2.0", "1.10.0", "1.9.3", "2.0.0-alpha", "1.10.0-rc.1"} {
+		m := model.Metadata{ID: "ledger", Title: "Ledger", Version: version}
+		if err := s.Context.Storage.Insert(gocontext.Background(), m.Key(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//1.10.0 is higher than 1.9.3 by precedence, not by string order, and pre-releases are skipped
+	w := serve(s, "GET", "/api/v1/apps/ledger/versions/latest", "", "")
+	var latest model.Metadata
+	if err := yaml.Unmarshal(w.Body.Bytes(), &latest); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || latest.Version != "1.10.0" {
+		t.Fatalf("latest is %q with status %d, expected 1.10.0", latest.Version, w.Code)
+	}
+
+	if w := serve(s, "GET", "/api/v1/apps/unknown/versions/latest", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("latest of unknown application: status %d, expected %d", w.Code, http.StatusNotFound)
+	}
+}
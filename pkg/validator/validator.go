@@ -3,14 +3,42 @@ package validator
 
 import (
 	"../model"
+	"../semver"
 	"bytes"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
+//Rules are the configurable rules applied in addition to the mandatory fields.
+//Empty AllowedLicenses means any license and zero MaxDescriptionLength means no limit.
+type Rules struct {
+	AllowedLicenses      []string
+	MaxDescriptionLength int
+	AllowPrerelease      bool
+}
+
+//rules holds the current *Rules, they can be replaced at runtime by SetRules
+var rules atomic.Value
+
+func init() {
+	rules.Store(&Rules{AllowPrerelease: true})
+}
+
+//SetRules replaces the rules applied by the following validations
+func SetRules(r Rules) {
+	rules.Store(&r)
+}
+
+//CurrentRules returns the rules currently applied
+func CurrentRules() Rules {
+	return *rules.Load().(*Rules)
+}
+
 //ValidateRequest defines set of rules to validate incoming request
 //This function can be injected to handlers to perform validation
 //Validates both mandatory fields as well as email format with regex.
@@ -31,8 +59,15 @@ func ValidateRequest(r *http.Request) (bool, string) {
 		return false, "Cannot unmarchall from request to object"
 	}
 
+	return ValidateMetadata(&m)
+}
+
+//ValidateMetadata applies the same rules as ValidateRequest to an already parsed metadata.
+//It is used where the final object is not the request body itself, e.g. after a merge patch.
+func ValidateMetadata(m *model.Metadata) (bool, string) {
+
 	//Check mandatory fields and email format
-	if result := checkFields(&m); len(result) > 0 {
+	if result := checkFields(m); len(result) > 0 {
 		return false, strings.Join(result, "-")
 	}
 	return true, ""
@@ -44,6 +79,8 @@ func checkFields(m *model.Metadata) []string {
 
 	if m.Version == "" || len(m.Version) == 0 {
 		emptyFields = append(emptyFields, "Version cannot be empty")
+	} else if !semver.IsValid(m.Version) {
+		emptyFields = append(emptyFields, "Version must be a semantic version like 1.0.8")
 	}
 	if m.Company == "" || len(m.Company) == 0 {
 		emptyFields = append(emptyFields, "Company cannot be empty")
@@ -60,6 +97,12 @@ func checkFields(m *model.Metadata) []string {
 	if m.Title == "" || len(m.Title) == 0 {
 		emptyFields = append(emptyFields, "Title cannot be empty")
 	}
+	if m.ID != "" && !isValidID(m.ID) {
+		emptyFields = append(emptyFields, "Id must consist of lowercase letters, digits and dashes")
+	}
+	if m.ID == "" && m.Title != "" && m.AppID() == "" {
+		emptyFields = append(emptyFields, "Id cannot be derived from title, id must be given")
+	}
 	if m.Website == "" || len(m.Website) == 0 {
 		emptyFields = append(emptyFields, "Website cannot be empty")
 	}
@@ -81,9 +124,43 @@ func checkFields(m *model.Metadata) []string {
 			}
 		}
 	}
+	emptyFields = append(emptyFields, checkRules(m, CurrentRules())...)
 	return emptyFields
 }
 
+//checkRules checks the configurable rules
+func checkRules(m *model.Metadata, r Rules) []string {
+	var problems []string
+
+	if len(r.AllowedLicenses) > 0 && m.License != "" && !containsFold(r.AllowedLicenses, m.License) {
+		problems = append(problems, "License must be one of "+strings.Join(r.AllowedLicenses, ", "))
+	}
+	if r.MaxDescriptionLength > 0 && len(m.Description) > r.MaxDescriptionLength {
+		problems = append(problems, "Description cannot be longer than "+strconv.Itoa(r.MaxDescriptionLength)+" characters")
+	}
+	if !r.AllowPrerelease {
+		if v, err := semver.Parse(m.Version); err == nil && v.IsPrerelease() {
+			problems = append(problems, "Prerelease versions are not allowed")
+		}
+	}
+	return problems
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//isValidID validates application id format, which is a slug like "my-valid-app"
+func isValidID(id string) bool {
+	var rxID = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+	return rxID.MatchString(id)
+}
+
 //isValidEmail validates email format
 func isValidEmail(email string) bool {
 	var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
@@ -0,0 +1,198 @@
+package workpool
+
+import (
+	gocontext "context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ErrSaturated is returned when a WorkRequest cannot be admitted to the pool within the enqueue timeout
+var ErrSaturated = errors.New("work pool is saturated")
+
+//ErrShuttingDown is returned when a WorkRequest is submitted after admission is closed
+var ErrShuttingDown = errors.New("work pool is shutting down")
+
+//drainPollInterval is how often Wait checks whether all WorkRequests are done
+const drainPollInterval = 10 * time.Millisecond
+
+//Admission is the admission-control layer in front of the work queue.
+//Work queue has a lane for each priority, WorkRequest is put into the lane of its priority.
+//Admission bounds the total number of WorkRequests in flight (queued + being processed)
+//and gives up after the enqueue timeout instead of blocking the caller forever.
+//A slot is taken when a WorkRequest is submitted and released by the worker
+//when it finishes the work. WorkRequests of a key which is already in flight wait for their turn
+//in the queue of the key instead of a lane, see keyQueues.
+//Once admission is closed, new WorkRequests are rejected so that the pool can be drained.
+type Admission struct {
+	lanes          []chan WorkRequest
+	keys           *keyQueues
+	slots          chan struct{}
+	enqueueTimeout time.Duration
+
+	//ready is signaled after a WorkRequest is put into a lane or released by its key, and after admission is closed,
+	//so that dispatcher can wait for works in any lane
+	ready chan struct{}
+
+	//submits hold read lock so that Close waits for the submits in progress.
+	//closed is atomic so that it can be checked without waiting for Close.
+	mu     sync.RWMutex
+	closed int32
+}
+
+//NewAdmission creates the admission-control layer and the work queue which has a lane of queueSize for each priority
+func NewAdmission(queueSize int, maxInFlight int, enqueueTimeout time.Duration) *Admission {
+	lanes := make([]chan WorkRequest, len(Priorities))
+	for i := range lanes {
+		lanes[i] = make(chan WorkRequest, queueSize)
+	}
+	return &Admission{
+		lanes:          lanes,
+		keys:           newKeyQueues(),
+		slots:          make(chan struct{}, maxInFlight),
+		enqueueTimeout: enqueueTimeout,
+		ready:          make(chan struct{}, 1),
+	}
+}
+
+//Submit puts the WorkRequest into the lane of its priority, or behind the WorkRequest in flight with the same key.
+//Returns ErrSaturated if either an in-flight slot or a place in the lane
+//cannot be obtained within the enqueue timeout.
+func (a *Admission) Submit(job WorkRequest) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.Closed() {
+		return ErrShuttingDown
+	}
+
+	timer := time.NewTimer(a.enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+	case <-timer.C:
+		return ErrSaturated
+	}
+
+	job.Enqueued = time.Now()
+	if !a.keys.enter(job) {
+		return nil
+	}
+	select {
+	case a.lanes[job.Priority.lane()] <- job:
+		a.wake()
+		return nil
+	case <-timer.C:
+		//works submitted for the same key meanwhile must not wait for this one
+		a.Finish(job)
+		return ErrSaturated
+	}
+}
+
+//Finish is called when the WorkRequest is processed, it releases the next WorkRequest of the same key
+//and the in-flight slot of the WorkRequest
+func (a *Admission) Finish(job WorkRequest) {
+	if a.keys.leave(job.Key) {
+		a.wake()
+	}
+	a.Done()
+}
+
+//wake signals dispatcher that there may be a work to dispatch
+func (a *Admission) wake() {
+	select {
+	case a.ready <- struct{}{}:
+	default:
+	}
+}
+
+//Close stops admitting WorkRequests and closes the lanes of work queue, Submit returns ErrShuttingDown afterwards.
+//WorkRequests already admitted stay in the work queue to be processed.
+//Since submits in progress hold the read lock, nothing is sent to the work queue after it is closed.
+func (a *Admission) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.Closed() {
+		for _, lane := range a.lanes {
+			close(lane)
+		}
+		atomic.StoreInt32(&a.closed, 1)
+		a.wake()
+	}
+}
+
+//Closed reports whether admission is closed
+func (a *Admission) Closed() bool {
+	return atomic.LoadInt32(&a.closed) == 1
+}
+
+//Wait waits until there is no WorkRequest in flight or ctx is done.
+//It should be called after Close, otherwise new WorkRequests may keep it waiting.
+func (a *Admission) Wait(ctx gocontext.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for a.InFlight() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+//Done releases the in-flight slot taken by a submitted WorkRequest
+func (a *Admission) Done() {
+	<-a.slots
+}
+
+//QueueDepth returns the number of WorkRequests waiting in all lanes of the work queue
+func (a *Admission) QueueDepth() int {
+	depth := 0
+	for _, lane := range a.lanes {
+		depth += len(lane)
+	}
+	return depth
+}
+
+//KeyWaiting returns the number of WorkRequests waiting for the WorkRequest in flight with the same key
+func (a *Admission) KeyWaiting() int {
+	return a.keys.len()
+}
+
+//LaneDepth returns the number of WorkRequests waiting in the lane of the given priority
+func (a *Admission) LaneDepth(priority Priority) int {
+	return len(a.lanes[priority.lane()])
+}
+
+//QueueCapacity returns the size of the work queue, sum of the lanes
+func (a *Admission) QueueCapacity() int {
+	capacity := 0
+	for _, lane := range a.lanes {
+		capacity += cap(lane)
+	}
+	return capacity
+}
+
+//InFlight returns the number of WorkRequests either queued or being processed
+func (a *Admission) InFlight() int {
+	return len(a.slots)
+}
+
+//MaxInFlight returns the upper bound of WorkRequests in flight
+func (a *Admission) MaxInFlight() int {
+	return cap(a.slots)
+}
+
+//Saturated reports whether a new WorkRequest would have to wait to be admitted
+func (a *Admission) Saturated() bool {
+	return a.InFlight() >= a.MaxInFlight() || a.QueueDepth() >= a.QueueCapacity()
+}
+
+//RetryAfter returns the number of seconds clients are advised to wait
+//before retrying a rejected request.
+func (a *Admission) RetryAfter() int {
+	return int(math.Max(1, math.Ceil(a.enqueueTimeout.Seconds())))
+}
@@ -0,0 +1,147 @@
+package workpool
+
+import (
+	"../config"
+	"../logger"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//Autoscaler grows and shrinks the work pool of a dispatcher between minimum and maximum number of workers.
+//Every interval it looks at the work queue and the workers:
+//
+//	grow    there are queued works and either they are more than the workers or they wait longer than
+//	        ScaleUpWait on average. One worker is added for every queued work, up to the maximum.
+//	shrink  work queue has been empty and some workers have been idle for ScaleDownDelay.
+//	        Pool shrinks to the highest number of busy workers seen in that period, down to the minimum.
+//
+//Surplus workers are retired by the dispatcher once they are idle. Decisions are logged and recorded in Metrics.
+//Autoscaler does nothing while it is disabled in configuration.
+type Autoscaler struct {
+	dispatcher *Dispatcher
+	log        *logger.AsyncLogger
+
+	mu     sync.Mutex
+	config config.AutoscaleConfig
+
+	//start of the current idle period and the highest number of busy workers in it
+	idleSince time.Time
+	idlePeak  int
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+//NewAutoscaler creates an autoscaler for the work pool of the dispatcher
+func NewAutoscaler(dispatcher *Dispatcher, cfg config.AutoscaleConfig) *Autoscaler {
+	return &Autoscaler{
+		dispatcher: dispatcher,
+		log:        dispatcher.log,
+		config:     cfg,
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+//SetConfig changes the limits and intervals of autoscaling, it is applied on the next interval
+func (a *Autoscaler) SetConfig(cfg config.AutoscaleConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config = cfg
+}
+
+func (a *Autoscaler) currentConfig() config.AutoscaleConfig {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.config
+}
+
+//start runs autoscaling until stop is called
+func (a *Autoscaler) start() {
+	go func() {
+		defer close(a.done)
+		for {
+			interval := a.currentConfig().Interval
+			if interval <= 0 {
+				interval = time.Second
+			}
+			select {
+			case <-time.After(interval):
+				a.scale(time.Now())
+			case <-a.quit:
+				return
+			}
+		}
+	}()
+}
+
+//stop stops autoscaling and waits until the last decision is applied
+func (a *Autoscaler) stop() {
+	close(a.quit)
+	<-a.done
+}
+
+//scale decides the size of the work pool and resizes it if needed
+func (a *Autoscaler) scale(now time.Time) {
+	cfg := a.currentConfig()
+	metrics := a.dispatcher.Metrics
+	wait, waited := metrics.takeWait()
+	peak := metrics.takePeakBusy()
+	if !cfg.Enabled() {
+		a.idleSince = time.Time{}
+		return
+	}
+
+	current := a.dispatcher.targetWorkers()
+	depth := a.dispatcher.Admission.QueueDepth()
+	target := current
+	var reason string
+
+	idle := depth == 0 && peak < current
+	if !idle {
+		a.idleSince = time.Time{}
+	}
+
+	switch {
+	case current < cfg.MinWorkers:
+		target = cfg.MinWorkers
+		reason = "below minimum workers"
+
+	case current > cfg.MaxWorkers:
+		target = cfg.MaxWorkers
+		reason = "above maximum workers"
+
+	case depth > 0 && (depth >= current || waited > 0 && wait > cfg.ScaleUpWait):
+		target = current + depth
+		if target > cfg.MaxWorkers {
+			target = cfg.MaxWorkers
+		}
+		reason = fmt.Sprintf("%d queued works, average wait %s", depth, wait)
+
+	case idle:
+		if a.idleSince.IsZero() {
+			a.idleSince, a.idlePeak = now, peak
+		} else if peak > a.idlePeak {
+			a.idlePeak = peak
+		}
+		if now.Sub(a.idleSince) < cfg.ScaleDownDelay {
+			return
+		}
+		target = a.idlePeak
+		if target < cfg.MinWorkers {
+			target = cfg.MinWorkers
+		}
+		reason = fmt.Sprintf("at most %d of %d workers busy for %s", a.idlePeak, current, now.Sub(a.idleSince).Round(time.Millisecond))
+		a.idleSince = time.Time{}
+	}
+
+	if target == current {
+		return
+	}
+	decision := ScaleDecision{Time: now, From: current, To: target, Reason: reason}
+	a.log.LogFields(logger.INFO, "Work pool is autoscaled",
+		logger.F("from", current), logger.F("to", target), logger.F("reason", reason))
+	metrics.scaled(decision)
+	a.dispatcher.SetWorkers(target)
+}
@@ -0,0 +1,99 @@
+package workpool
+
+import (
+	"../config"
+	"../context"
+	"../logger"
+	"bytes"
+	gocontext "context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//testAutoscaler returns the autoscaler of a dispatcher which is not started, so queued works stay in the queue.
+//Pool scales between 1 and 6 workers and the given number of workers is taken as its current size.
+func testAutoscaler(current int, logged *bytes.Buffer) (*Autoscaler, *Dispatcher, *logger.AsyncLogger) {
+	log := logger.CreateAsyncLogger()
+	for level := range logger.LogLevelStr {
+		log.SetSink(logger.LogLevel(level), logged)
+	}
+	c := config.Default()
+	c.WorkPool.Autoscale = config.AutoscaleConfig{
+		MinWorkers:     1,
+		MaxWorkers:     6,
+		Interval:       time.Second,
+		ScaleUpWait:    100 * time.Millisecond,
+		ScaleDownDelay: time.Minute,
+	}
+	ctx := &context.AppContext{Logger: log, Config: config.NewStore(c, nil, log)}
+	d := NewDispatcher(NewAdmission(10, 100, time.Second), current, NewJobRegistry(), ctx)
+	atomic.StoreInt32(&d.target, int32(current))
+	return d.autoscaler, d, log
+}
+
+func TestAutoscaleUp(t *testing.T) {
+	var logged bytes.Buffer
+	a, d, log := testAutoscaler(2, &logged)
+	now := time.Now()
+
+	//a queued work which is fewer than the workers and has not waited long is not a reason to grow
+	submit(t, d, WorkRequest{})
+	a.scale(now)
+	if last := d.Metrics.Snapshot().LastScale; last != nil {
+		t.Fatalf("pool is scaled from %d to %d for a single queued work", last.From, last.To)
+	}
+
+	//one worker is added for every queued work, up to the maximum
+	for i := 0; i < 3; i++ {
+		submit(t, d, WorkRequest{})
+	}
+	a.scale(now.Add(time.Second))
+	metrics := d.Metrics.Snapshot()
+	if metrics.ScaleUps != 1 || metrics.LastScale == nil || metrics.LastScale.From != 2 || metrics.LastScale.To != 6 {
+		t.Fatalf("4 queued works scaled the pool as %+v, expected from 2 to 6", metrics.LastScale)
+	}
+	if requested := atomic.LoadInt32(&d.requested); requested != 6 {
+		t.Fatalf("dispatcher is asked for %d workers, expected 6", requested)
+	}
+
+	//decisions are expected, so they are not warnings
+	if err := log.Close(gocontext.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logged.String(), "INFO: Work pool is autoscaled") {
+		t.Fatalf("scaling is not logged at INFO: %s", logged.String())
+	}
+}
+
+func TestAutoscaleDown(t *testing.T) {
+	var logged bytes.Buffer
+	a, d, _ := testAutoscaler(4, &logged)
+	now := time.Now()
+
+	//at most 2 of 4 workers have been busy since the pool became idle
+	atomic.StoreInt32(&d.Metrics.peakBusy, 2)
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, 59 * time.Second} {
+		a.scale(now.Add(elapsed))
+		if last := d.Metrics.Snapshot().LastScale; last != nil {
+			t.Fatalf("pool idle for %s is scaled from %d to %d before scale down delay", elapsed, last.From, last.To)
+		}
+	}
+	a.scale(now.Add(time.Minute))
+	metrics := d.Metrics.Snapshot()
+	if metrics.ScaleDowns != 1 || metrics.LastScale == nil || metrics.LastScale.From != 4 || metrics.LastScale.To != 2 {
+		t.Fatalf("idle pool is scaled as %+v, expected from 4 to the peak of 2", metrics.LastScale)
+	}
+	if requested := atomic.LoadInt32(&d.requested); requested != 2 {
+		t.Fatalf("dispatcher is asked for %d workers, expected 2", requested)
+	}
+
+	//a queued work ends the idle period, so the pool is not shrunk again until it is idle for the whole delay
+	atomic.StoreInt32(&d.target, 2)
+	submit(t, d, WorkRequest{})
+	a.scale(now.Add(2 * time.Minute))
+	if metrics := d.Metrics.Snapshot(); metrics.ScaleDowns != 1 || metrics.ScaleUps != 0 {
+		t.Fatalf("pool with a queued work is scaled: %d up, %d down", metrics.ScaleUps, metrics.ScaleDowns)
+	}
+}
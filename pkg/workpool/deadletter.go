@@ -0,0 +1,122 @@
+package workpool
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+//ErrDiscarded is the error of a job whose dead-lettered work is discarded
+var ErrDiscarded = errors.New("dead-lettered work has been discarded")
+
+//DeadLetter is a WorkRequest which still failed after all attempts
+type DeadLetter struct {
+	Work           WorkRequest `json:"work" yaml:"work"`
+	Attempts       int         `json:"attempts" yaml:"attempts"`
+	Error          string      `json:"error" yaml:"error"`
+	DeadLetteredAt time.Time   `json:"deadLetteredAt" yaml:"deadLetteredAt"`
+}
+
+//DeadLetterQueue keeps the WorkRequests which could not be processed after retries,
+//so that they can be inspected and then replayed or discarded by an operator.
+//It holds at most size works, the oldest one is dropped when it is full.
+type DeadLetterQueue struct {
+	mu      sync.RWMutex
+	size    int
+	order   []uuid.UUID
+	letters map[uuid.UUID]DeadLetter
+}
+
+//NewDeadLetterQueue creates an empty dead-letter queue which holds at most size works
+func NewDeadLetterQueue(size int) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		size:    size,
+		letters: make(map[uuid.UUID]DeadLetter),
+	}
+}
+
+//Add puts the dead letter into the queue and returns the one dropped to make room for it, if any
+func (q *DeadLetterQueue) Add(letter DeadLetter) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dropped DeadLetter
+	var ok bool
+	if _, exists := q.letters[letter.Work.ID]; exists {
+		q.remove(letter.Work.ID)
+	} else if len(q.order) >= q.size && len(q.order) > 0 {
+		dropped, ok = q.letters[q.order[0]], true
+		q.remove(q.order[0])
+	}
+	q.order = append(q.order, letter.Work.ID)
+	q.letters[letter.Work.ID] = letter
+	return dropped, ok
+}
+
+//List returns the dead letters, oldest first
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(q.order))
+	for _, id := range q.order {
+		letters = append(letters, q.letters[id])
+	}
+	return letters
+}
+
+//Get returns the dead letter of the WorkRequest with the given id
+func (q *DeadLetterQueue) Get(id uuid.UUID) (DeadLetter, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	letter, ok := q.letters[id]
+	return letter, ok
+}
+
+//Remove takes the dead letter of the WorkRequest with the given id out of the queue
+func (q *DeadLetterQueue) Remove(id uuid.UUID) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letter, ok := q.letters[id]
+	if ok {
+		q.remove(id)
+	}
+	return letter, ok
+}
+
+//Len returns the number of dead letters
+func (q *DeadLetterQueue) Len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.order)
+}
+
+func (q *DeadLetterQueue) remove(id uuid.UUID) {
+	delete(q.letters, id)
+	for i, queued := range q.order {
+		if queued == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			return
+		}
+	}
+}
+
+//Replay takes the dead letter out of the queue and submits its WorkRequest again with the same job id.
+//Dead letter is put back if the work cannot be admitted.
+func (q *DeadLetterQueue) Replay(id uuid.UUID, admission *Admission, jobs *JobRegistry) (bool, error) {
+	letter, ok := q.Remove(id)
+	if !ok {
+		return false, nil
+	}
+	ctx := jobs.Add(gocontext.Background(), id)
+	if err := admission.Submit(letter.Work.WithContext(ctx)); err != nil {
+		jobs.DeadLetter(id, errors.New(letter.Error))
+		q.Add(letter)
+		return true, err
+	}
+	return true, nil
+}
@@ -2,43 +2,104 @@
 Package workpool implements a worker thread-pool approach to handle POST requests
 Actors for this approach are:
 
-WorkRequest - Work item that can processed by a worker. It is request payload in our case.
+WorkRequest - Work item that can processed by a worker. It is request payload in our case, or the arguments of a job
+			  of another type, e.g. a webhook delivery. Workers process it by the Handler registered for its type.
 Worker      - It can process WorkItems assigned by dispatcher using Workers own work queue.
   			  Worker is responsible for registering itself to WorkerQueue
 WorkerQueue - It is a buffered channel of channels. Workers use the channels goes into this channel to retrieve  works
 WorkQueue 	- WorkRequests are being pushed to that queue so that dispatcher can pick it up and assign to workers.
+			  It has a lane for each priority (high, normal, bulk), dispatcher serves the lanes by their weights.
+			  Works of a key which is in flight wait for it outside the lanes, so works of the same key
+			  are processed one by one in submission order.
+Admission   - Admission-control layer in front of WorkQueue. It bounds the number of WorkRequests in flight
+			  and rejects new ones when the pool is saturated instead of blocking the caller.
 
 Especially under heavy load, (e.g. 1M per minute) this method works quite effective and decrease latency / delay dramatically
 
 Server is responsible for creating teh WorkRequest channel and starting dispatcher.
+WorkRequests are submitted through Admission so that handlers are never blocked by a full work queue.
 */
 
 package workpool
 
 import (
+	"../config"
 	"../context"
 	"../logger"
+	gocontext "context"
+	"strconv"
+	"sync/atomic"
 )
 
+//Dispatcher assigns works in the work queue to available workers.
+//WorkQueues are the lanes of the work queue from the highest priority, works are taken from them
+//by weighted round robin as configured in workpool.priority.weights.
+//Number of workers can be changed while it is running by SetWorkers, autoscaler uses it to follow the load.
+//workers are owned by the dispatching goroutine, other goroutines store the size they ask for in requested
+//and signal it through resize channel, so asking never blocks, even before dispatching starts or after it stops.
 type Dispatcher struct {
 	WorkerQueue chan chan WorkRequest
-	WorkQueue   chan WorkRequest
+	WorkQueues  []chan WorkRequest
+	Admission   *Admission
 	Ctx         *context.AppContext
+	Jobs        *JobRegistry
+	Metrics     *Metrics
+	DeadLetters *DeadLetterQueue
+	Handlers    *Handlers
 	MaxWorkers  int
+	log         *logger.AsyncLogger
+	autoscaler  *Autoscaler
+	scheduler   *scheduler
+	workers     map[chan WorkRequest]*Worker
+	retiring    int
+	target      int32
+	requested   int32
+	resize      chan struct{}
+	quit        chan struct{}
+	done        chan struct{}
 }
 
-//NewDispatcher creates the WorkerQueue using max worker number received as argument.
-//It also initialize context and work queue.
-func NewDispatcher(workQueue chan WorkRequest, maxWorkers int, ctx *context.AppContext) *Dispatcher {
+//NewDispatcher creates the WorkerQueue using max worker number received as argument,
+//or the maximum of autoscaling if it is higher, so that workers added later do not wait to register.
+//It also initialize context, job registry and work queue which is owned by admission.
+//If the context has a config store, pool is autoscaled as configured in workpool.autoscale. Otherwise
+//number of workers is MaxWorkers and it follows workpool.workers on reload.
+func NewDispatcher(admission *Admission, maxWorkers int, jobs *JobRegistry, ctx *context.AppContext) *Dispatcher {
 
-	WorkerQueue := make(chan chan WorkRequest, maxWorkers)
+	queueSize := maxWorkers
+	if autoscaleMax := workPoolConfig(ctx).Autoscale.MaxWorkers; autoscaleMax > queueSize {
+		queueSize = autoscaleMax
+	}
+	WorkerQueue := make(chan chan WorkRequest, queueSize)
 
-	return &Dispatcher{
+	d := &Dispatcher{
 		WorkerQueue: WorkerQueue,
-		WorkQueue:   workQueue,
+		WorkQueues:  admission.lanes,
+		Admission:   admission,
 		Ctx:         ctx,
+		Jobs:        jobs,
+		Metrics:     NewMetrics(),
+		DeadLetters: NewDeadLetterQueue(deadLetterSize(ctx)),
+		Handlers:    NewHandlers(ctx),
 		MaxWorkers:  maxWorkers,
+		log:         ctx.Logger.Component("workpool"),
+		workers:     make(map[chan WorkRequest]*Worker),
+		resize:      make(chan struct{}, 1),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	d.scheduler = newScheduler(d.WorkQueues, workPoolConfig(ctx).Priority.Weights)
+	if ctx.Config != nil {
+		d.autoscaler = NewAutoscaler(d, ctx.Config.Current().WorkPool.Autoscale)
+		ctx.Config.Subscribe(func(old *config.Config, new *config.Config) {
+			d.autoscaler.SetConfig(new.WorkPool.Autoscale)
+			d.scheduler.setWeights(new.WorkPool.Priority.Weights)
+			if !new.WorkPool.Autoscale.Enabled() && old.WorkPool.Workers != new.WorkPool.Workers {
+				d.SetWorkers(new.WorkPool.Workers)
+			}
+		})
 	}
+	return d
 }
 
 //StartDispatcher creates the workers and starts them then starts dispatching.
@@ -46,32 +107,184 @@ func (d *Dispatcher) StartDispatcher() {
 
 	//First create workers and make them available to work!
 	for i := 0; i < d.MaxWorkers; i++ {
-		worker := NewWorker(d.WorkerQueue, d.Ctx)
-		worker.start()
+		d.addWorker()
+	}
+	atomic.StoreInt32(&d.target, int32(d.MaxWorkers))
+	if d.autoscaler != nil {
+		d.autoscaler.start()
 	}
 
 	go func() {
+		defer close(d.done)
+
+		//Dispatcher first waits for an available worker and only then takes the next work
+		//from the work queue. So works stay in the work queue until a worker can pick them up
+		//and the length of the work queue is the real backlog of the pool.
+		//worker is the available worker held by dispatcher, nil channels are never selected
+		var worker chan WorkRequest
 		for {
-			select {
+			if worker != nil && d.retiring > 0 {
+				d.retireWorker(worker)
+				worker = nil
+				continue
+			}
+
+			//lanes are not changed after admission is closed, so closed must be checked before taking a work
+			closed := d.Admission.Closed()
 
-			//Dispatcher checks work queue and whenever it receives a work (which is handled and passed by http handler)
-			//it just starts a new goroutine in order not to wait for worker queue for available workers.
-			case work := <-d.WorkQueue:
+			var workerQueue chan chan WorkRequest
+			var ready chan struct{}
+			if worker == nil {
+				workerQueue = d.WorkerQueue
+			} else if work, ok := d.next(); ok {
+				d.log.Log(logger.INFO, "Work ", work.ID.String(), " received from WorkQueue", " type: ", string(work.JobType()),
+					" key: ", work.Key, " priority: ", work.Priority.String())
 
-				d.Ctx.Logger.Log(logger.INFO, "Work ", work.ID.String(), " received from WorkQueue", " version: ", work.Payload.Version)
-				go func() {
+				//dispatch the job to available worker.
+				worker <- work
+				worker = nil
+				continue
+			} else if closed && d.Admission.KeyWaiting() == 0 {
+				//work queue is closed and drained
+				return
+			} else {
+				//all lanes are empty, wait until a work is put into any of them or released by its key
+				ready = d.Admission.ready
+			}
 
-					//get a available worker which can work on this
-					worker := <-d.WorkerQueue
+			select {
+			case <-d.resize:
+				d.resizeTo(int(atomic.LoadInt32(&d.requested)))
 
-					d.Ctx.Logger.Log(logger.INFO, "Available Worker channel received from WorkerQueue")
+			case worker = <-workerQueue:
+				d.log.Log(logger.INFO, "Available Worker channel received from WorkerQueue")
 
-					//dispatch the job to available worker.
-					worker <- work
+			case <-ready:
 
-				}()
+			case <-d.quit:
+				return
 			}
 		}
 	}()
 
 }
+
+//next takes the work to dispatch, works released by their key first since they have already waited for their turn
+func (d *Dispatcher) next() (WorkRequest, bool) {
+	if work, ok := d.Admission.keys.next(); ok {
+		return work, true
+	}
+	return d.scheduler.next()
+}
+
+//deadLetterSize returns the configured size of the dead-letter queue
+func deadLetterSize(ctx *context.AppContext) int {
+	return workPoolConfig(ctx).DeadLetterSize
+}
+
+//workPoolConfig returns the current configuration of the work pool, the default one if context has no config store
+func workPoolConfig(ctx *context.AppContext) config.WorkPoolConfig {
+	if ctx.Config == nil {
+		return config.Default().WorkPool
+	}
+	return ctx.Config.Current().WorkPool
+}
+
+//SetWorkers changes the number of workers, it does not wait for the change to be applied.
+//New workers are started at once, surplus workers are retired when they finish their current work.
+//A size asked before the pool is started is applied when dispatching starts, the last one wins.
+func (d *Dispatcher) SetWorkers(size int) {
+	if size < 1 {
+		size = 1
+	}
+	atomic.StoreInt32(&d.requested, int32(size))
+	select {
+	case d.resize <- struct{}{}:
+	default:
+		//a signal is already pending, it applies the size stored above
+	}
+}
+
+//Workers returns the number of running workers, including the ones which will be retired
+func (d *Dispatcher) Workers() int {
+	return int(atomic.LoadInt32(&d.Metrics.workers))
+}
+
+//targetWorkers returns the number of workers requested by the last resize
+func (d *Dispatcher) targetWorkers() int {
+	return int(atomic.LoadInt32(&d.target))
+}
+
+//resizeTo starts or retires workers so that there will be size workers, called by dispatching goroutine
+func (d *Dispatcher) resizeTo(size int) {
+	current := len(d.workers) - d.retiring
+	d.log.Log(logger.INFO, "Work pool is resized from ", strconv.Itoa(current), " to ", strconv.Itoa(size), " workers")
+	for ; current < size; current++ {
+		//cancel pending retirements before starting new workers
+		if d.retiring > 0 {
+			d.retiring--
+		} else {
+			d.addWorker()
+		}
+	}
+	if current > size {
+		d.retiring += current - size
+	}
+	atomic.StoreInt32(&d.target, int32(size))
+}
+
+func (d *Dispatcher) addWorker() {
+	worker := NewWorker(d.WorkerQueue, d.Admission, d.Jobs, d.Metrics, d.DeadLetters, d.Handlers, d.Ctx)
+	worker.start()
+	d.workers[worker.work] = worker
+	d.Metrics.addWorkers(1)
+}
+
+//retireWorker stops an idle worker. Worker is idle since its channel is taken from WorkerQueue,
+//so it is waiting for a work or quit signal.
+func (d *Dispatcher) retireWorker(work chan WorkRequest) {
+	worker := d.workers[work]
+	delete(d.workers, work)
+	d.retiring--
+	d.Metrics.addWorkers(-1)
+	worker.stop()
+	d.log.Log(logger.INFO, "Worker ", worker.ID.String(), " has been retired")
+}
+
+//Stop drains the pool: it closes admission and the work queue so that no new WorkRequest is accepted,
+//waits until the queued and running WorkRequests are processed and then stops dispatching and workers.
+//If ctx is done before the pool is drained, WorkRequests left in the work queue or waiting for their key are returned
+//unprocessed with ctx error, their jobs are marked as failed. Works already running are let finish in background.
+func (d *Dispatcher) Stop(ctx gocontext.Context) ([]WorkRequest, error) {
+	if d.autoscaler != nil {
+		d.autoscaler.stop()
+	}
+	d.Admission.Close()
+	err := d.Admission.Wait(ctx)
+
+	//dispatching must be stopped before the rest of the work queue is taken
+	close(d.quit)
+	<-d.done
+
+	//works waiting for their key come after the lanes so that they are resubmitted in order
+	var leftovers []WorkRequest
+	for _, lane := range d.WorkQueues {
+		for work := range lane {
+			leftovers = append(leftovers, work)
+		}
+	}
+	leftovers = append(leftovers, d.Admission.keys.drain()...)
+	for _, work := range leftovers {
+		d.Jobs.Fail(work.ID, ErrShuttingDown)
+		d.Admission.Done()
+	}
+	if err != nil {
+		d.log.Log(logger.WARNING, "Work pool could not be drained, ", strconv.Itoa(len(leftovers)), " queued and ",
+			strconv.Itoa(d.Admission.InFlight()), " running works left")
+	}
+
+	for _, worker := range d.workers {
+		worker.stop()
+	}
+	return leftovers, err
+}
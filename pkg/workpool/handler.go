@@ -0,0 +1,127 @@
+package workpool
+
+import (
+	"../context"
+	"../memstore"
+	"../model"
+	"../validator"
+	gocontext "context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+//JobType names the kind of a WorkRequest, workers process it by the Handler registered for its type
+type JobType string
+
+//Job types of the record operations, metadata works created by the server have these types
+const (
+	TypeInsert JobType = "insert"
+	TypeUpdate JobType = "update"
+	TypePatch  JobType = "patch"
+	TypeDelete JobType = "delete"
+)
+
+//ErrUnknownJobType is the error of a work whose type has no handler
+var ErrUnknownJobType = errors.New("no handler for job type")
+
+//ErrKeyChanged is returned when a patch tries to change the id or version which are the key of the record
+var ErrKeyChanged = errors.New("id and version of a record cannot be changed")
+
+//Handler processes the works of a job type and gives up when ctx is done.
+//Failures are retried with backoff unless they are marked by Permanent or they are permanent storage errors.
+//Handler is called by many workers at the same time.
+type Handler interface {
+	Handle(ctx gocontext.Context, work WorkRequest) error
+}
+
+//HandlerFunc lets an ordinary function be used as a Handler
+type HandlerFunc func(ctx gocontext.Context, work WorkRequest) error
+
+//Handle calls f(ctx, work)
+func (f HandlerFunc) Handle(ctx gocontext.Context, work WorkRequest) error {
+	return f(ctx, work)
+}
+
+//Handlers is the registry of the handlers by job type, it is shared by the workers.
+//Handlers can be registered while the pool is running, e.g. deliveries of webhooks, exports or reindexing
+//are submitted as works of their own types and processed by the same workers as the record operations.
+type Handlers struct {
+	mu       sync.RWMutex
+	handlers map[JobType]Handler
+}
+
+//NewHandlers creates a registry with the handlers of the record operations on the storage of the context
+func NewHandlers(ctx *context.AppContext) *Handlers {
+	h := &Handlers{handlers: make(map[JobType]Handler)}
+	records := recordHandler{ctx: ctx}
+	h.Register(TypeInsert, HandlerFunc(records.insert))
+	h.Register(TypeUpdate, HandlerFunc(records.update))
+	h.Register(TypePatch, HandlerFunc(records.patch))
+	h.Register(TypeDelete, HandlerFunc(records.delete))
+	return h
+}
+
+//Register sets the handler of the job type, it replaces the handler registered before
+func (h *Handlers) Register(jobType JobType, handler Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[jobType] = handler
+}
+
+//Handler returns the handler of the job type
+func (h *Handlers) Handler(jobType JobType) (Handler, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	handler, ok := h.handlers[jobType]
+	return handler, ok
+}
+
+//Types returns the registered job types in alphabetical order
+func (h *Handlers) Types() []JobType {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	types := make([]JobType, 0, len(h.handlers))
+	for jobType := range h.handlers {
+		types = append(types, jobType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+//recordHandler applies the record operations to the storage, storage gives up when ctx is done
+type recordHandler struct {
+	ctx *context.AppContext
+}
+
+func (r recordHandler) insert(ctx gocontext.Context, work WorkRequest) error {
+	return r.ctx.Storage.Insert(ctx, work.Key, work.Payload)
+}
+
+func (r recordHandler) update(ctx gocontext.Context, work WorkRequest) error {
+	return r.ctx.Storage.Update(ctx, work.Key, work.Payload)
+}
+
+//patch applies the merge patch to the current state of the record at the time worker picks the work
+func (r recordHandler) patch(ctx gocontext.Context, work WorkRequest) error {
+	current, ok := r.ctx.Storage.Read(work.Key).(model.Metadata)
+	if !ok {
+		return memstore.ErrNotFound
+	}
+	patched, err := applyMergePatch(current, work.Patch)
+	if err != nil {
+		return Permanent(err)
+	}
+	if patched.Key() != work.Key {
+		return ErrKeyChanged
+	}
+	if isValid, errorStr := validator.ValidateMetadata(&patched); !isValid {
+		return Permanent(errors.New(errorStr))
+	}
+	return r.ctx.Storage.Update(ctx, work.Key, patched)
+}
+
+func (r recordHandler) delete(ctx gocontext.Context, work WorkRequest) error {
+	return r.ctx.Storage.Delete(ctx, work.Key)
+}
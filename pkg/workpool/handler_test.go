@@ -0,0 +1,79 @@
+package workpool
+
+import (
+	"../context"
+	"../memstore"
+	"../model"
+	gocontext "context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHandlersRegistry(t *testing.T) {
+	h := NewHandlers(&context.AppContext{Storage: memstore.CreateInMemDB()})
+	if types := h.Types(); !reflect.DeepEqual(types, []JobType{TypeDelete, TypeInsert, TypePatch, TypeUpdate}) {
+		t.Fatalf("registry has %v, expected the record operations", types)
+	}
+	if _, ok := h.Handler("export"); ok {
+		t.Fatal("unregistered type has a handler")
+	}
+
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	h.Register("export", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error { return errFirst }))
+	h.Register("export", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error { return errSecond }))
+	handler, ok := h.Handler("export")
+	if !ok {
+		t.Fatal("registered type has no handler")
+	}
+	if err := handler.Handle(gocontext.Background(), WorkRequest{}); err != errSecond {
+		t.Fatalf("handler returns %v, expected the one registered last", err)
+	}
+	if types := h.Types(); len(types) != 5 || types[1] != "export" {
+		t.Fatalf("registry has %v after register", types)
+	}
+}
+
+func TestJobType(t *testing.T) {
+	tests := []struct {
+		work    WorkRequest
+		jobType JobType
+	}{
+		{WorkRequest{Op: OpInsert}, TypeInsert},
+		{WorkRequest{Op: OpUpdate}, TypeUpdate},
+		{WorkRequest{Op: OpPatch}, TypePatch},
+		{WorkRequest{Op: OpDelete}, TypeDelete},
+		//explicit type wins over the operation
+		{WorkRequest{Op: OpDelete, Type: "export"}, "export"},
+	}
+	for _, test := range tests {
+		if jobType := test.work.JobType(); jobType != test.jobType {
+			t.Errorf("type of %+v is %s, expected %s", test.work, jobType, test.jobType)
+		}
+	}
+}
+
+func TestWorksDispatchedByType(t *testing.T) {
+	d := testDispatcher(t, 2)
+	exported := make(chan string, 1)
+	d.Handlers.Register("export", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error {
+		exported <- work.Key
+		return nil
+	}))
+
+	//record operations are handled by the registered record handlers
+	m := model.Metadata{ID: "ledger", Title: "Ledger", Version: "1.0.0"}
+	if job := waitJob(t, d, submit(t, d, WorkRequest{Op: OpInsert, Key: m.Key(), Payload: m})); job.Status != JobSucceeded {
+		t.Fatalf("insert is %s: %s", job.Status, job.Error)
+	}
+	if d.Ctx.Storage.Read(m.Key()) == nil {
+		t.Fatal("inserted record is not in storage")
+	}
+
+	if job := waitJob(t, d, submit(t, d, WorkRequest{Type: "export", Key: m.Key()})); job.Status != JobSucceeded {
+		t.Fatalf("export is %s: %s", job.Status, job.Error)
+	}
+	if key := <-exported; key != m.Key() {
+		t.Fatalf("export handler got key %s, expected %s", key, m.Key())
+	}
+}
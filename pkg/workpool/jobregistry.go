@@ -0,0 +1,290 @@
+package workpool
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+//JobStatus defines the states a WorkRequest goes through
+//from the moment it is accepted by the server until a worker finishes it.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+
+	//JobDeadLettered means that the work failed after all attempts and it is in the dead-letter queue
+	JobDeadLettered JobStatus = "dead-lettered"
+	JobCancelled    JobStatus = "cancelled"
+)
+
+//ErrJobNotFound is returned when there is no job with the given id
+var ErrJobNotFound = errors.New("job not found")
+
+//ErrJobFinished is returned when a job cannot be cancelled since it has already finished
+var ErrJobFinished = errors.New("job has already finished")
+
+//ErrJobCancelled is the error of a cancelled job
+var ErrJobCancelled = errors.New("job has been cancelled")
+
+//ErrJobTimedOut is the error of a job which could not be processed within its timeout
+var ErrJobTimedOut = errors.New("job has timed out")
+
+//JobRetention defines how long finished jobs are kept in the registry
+//so that clients still have a chance to poll their status.
+const JobRetention = 1 * time.Hour
+
+//Job is the status record of a WorkRequest.
+//Key is the storage key of the resulting record and Error is set
+//if the worker could not process the request. Retries is the number of failed attempts retried,
+//Error is the error of the last one while the job is retried.
+type Job struct {
+	ID         uuid.UUID  `json:"id" yaml:"id"`
+	Status     JobStatus  `json:"status" yaml:"status"`
+	CreatedAt  time.Time  `json:"createdAt" yaml:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" yaml:"finishedAt,omitempty"`
+	Key        string     `json:"key,omitempty" yaml:"key,omitempty"`
+	Retries    int        `json:"retries,omitempty" yaml:"retries,omitempty"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+//JobRegistry keeps track of the jobs. It is shared by server, dispatcher and workers
+//so that server can report what happened to a WorkRequest it has put into the work queue.
+//Every unfinished job has a context which is canceled when the job is cancelled.
+//Finished jobs are listed in the order they finish, so expired ones are always at the head of the list.
+//A job can claim a storage key, e.g. the key of the record it inserts, so that no other job claims it until it finishes.
+type JobRegistry struct {
+	mu       sync.RWMutex
+	jobs     map[uuid.UUID]*Job
+	cancels  map[uuid.UUID]gocontext.CancelFunc
+	finished []finishedJob
+	claims   map[string]uuid.UUID
+	claimed  map[uuid.UUID]string
+}
+
+//finishedJob is an entry of the list of finished jobs
+type finishedJob struct {
+	id uuid.UUID
+	at time.Time
+}
+
+//NewJobRegistry creates an empty job registry
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{
+		jobs:    make(map[uuid.UUID]*Job),
+		cancels: make(map[uuid.UUID]gocontext.CancelFunc),
+		claims:  make(map[string]uuid.UUID),
+		claimed: make(map[uuid.UUID]string),
+	}
+}
+
+//Add registers a new job in queued state and returns the context of the job derived from ctx,
+//it should be given to the WorkRequest by WithContext.
+//Finished jobs older than JobRetention are removed at the same time.
+func (r *JobRegistry) Add(ctx gocontext.Context, id uuid.UUID) gocontext.Context {
+	jobCtx, cancel := gocontext.WithCancel(ctx)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(now)
+	r.release(id)
+	r.jobs[id] = &Job{ID: id, Status: JobQueued, CreatedAt: now}
+	r.cancels[id] = cancel
+	return jobCtx
+}
+
+//Start marks the job as picked up by a worker, unless it has been cancelled meanwhile
+func (r *JobRegistry) Start(id uuid.UUID) {
+	r.update(id, func(job *Job) {
+		if job.Status != JobQueued {
+			return
+		}
+		now := time.Now()
+		job.Status = JobRunning
+		job.StartedAt = &now
+	})
+}
+
+//Succeed marks the job as finished and records the key of the resulting record
+func (r *JobRegistry) Succeed(id uuid.UUID, key string) {
+	r.finish(id, func(job *Job) {
+		now := time.Now()
+		job.Status = JobSucceeded
+		job.FinishedAt = &now
+		job.Key = key
+	})
+}
+
+//Fail marks the job as finished with the given error
+func (r *JobRegistry) Fail(id uuid.UUID, err error) {
+	r.finish(id, func(job *Job) {
+		now := time.Now()
+		job.Status = JobFailed
+		job.FinishedAt = &now
+		job.Error = err.Error()
+	})
+}
+
+//Retry records a failed attempt of the job which is going to be retried
+func (r *JobRegistry) Retry(id uuid.UUID, err error) {
+	r.update(id, func(job *Job) {
+		job.Retries++
+		job.Error = err.Error()
+	})
+}
+
+//DeadLetter marks the job as finished with the given error and moved to the dead-letter queue
+func (r *JobRegistry) DeadLetter(id uuid.UUID, err error) {
+	r.finish(id, func(job *Job) {
+		now := time.Now()
+		job.Status = JobDeadLettered
+		job.FinishedAt = &now
+		job.Error = err.Error()
+	})
+}
+
+//Cancelled marks the job as cancelled
+func (r *JobRegistry) Cancelled(id uuid.UUID) {
+	r.finish(id, cancelled)
+}
+
+//cancelled marks the job as cancelled
+func cancelled(job *Job) {
+	now := time.Now()
+	job.Status = JobCancelled
+	job.FinishedAt = &now
+	job.Error = ErrJobCancelled.Error()
+}
+
+//Cancel cancels the context of an unfinished job. A queued job is cancelled at once and it is skipped
+//when a worker picks it up, a running job is cancelled by its worker once storage gives up.
+//A running job ends as cancelled only if its handler gives up. If the handler finishes the work anyway,
+//the job reports how it has really ended, e.g. succeeded with the key of the record written.
+//Returns ErrJobNotFound or ErrJobFinished if the job cannot be cancelled.
+func (r *JobRegistry) Cancel(id uuid.UUID) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	if job.FinishedAt != nil {
+		return *job, ErrJobFinished
+	}
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+	}
+	if job.Status == JobQueued {
+		cancelled(job)
+		r.release(id)
+		r.unclaim(id)
+		r.finished = append(r.finished, finishedJob{id: id, at: *job.FinishedAt})
+	}
+	return *job, nil
+}
+
+//Remove deletes the job with the given id.
+//Used when a WorkRequest has been registered but could not be admitted to the pool.
+func (r *JobRegistry) Remove(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.release(id)
+	r.unclaim(id)
+	delete(r.jobs, id)
+}
+
+//Claim reserves the key for the job with the given id until the job finishes or it is removed.
+//Job can claim the key before it is added. Returns false if the key is claimed by another job.
+func (r *JobRegistry) Claim(key string, id uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if owner, ok := r.claims[key]; ok && owner != id {
+		return false
+	}
+	r.claims[key] = id
+	r.claimed[id] = key
+	return true
+}
+
+//Unclaim releases the key claimed by the job with the given id, if any
+func (r *JobRegistry) Unclaim(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unclaim(id)
+}
+
+//Get returns a copy of the job with the given id
+func (r *JobRegistry) Get(id uuid.UUID) (Job, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if job, ok := r.jobs[id]; ok {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+//finish applies fn to the job with the given id like update and releases the context of the job
+func (r *JobRegistry) finish(id uuid.UUID, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+		if job.FinishedAt != nil {
+			r.finished = append(r.finished, finishedJob{id: id, at: *job.FinishedAt})
+		}
+	}
+	r.release(id)
+	r.unclaim(id)
+}
+
+//unclaim releases the key claimed by a job, caller must hold the lock
+func (r *JobRegistry) unclaim(id uuid.UUID) {
+	if key, ok := r.claimed[id]; ok {
+		delete(r.claims, key)
+		delete(r.claimed, id)
+	}
+}
+
+//expire removes the jobs finished more than JobRetention ago from the head of the list of finished jobs.
+//A job is removed only if it has not been added again since its entry, caller must hold the lock.
+func (r *JobRegistry) expire(now time.Time) {
+	for len(r.finished) > 0 && now.Sub(r.finished[0].at) > JobRetention {
+		entry := r.finished[0]
+		r.finished[0] = finishedJob{}
+		r.finished = r.finished[1:]
+		if job, ok := r.jobs[entry.id]; ok && job.FinishedAt != nil && job.FinishedAt.Equal(entry.at) {
+			delete(r.jobs, entry.id)
+		}
+	}
+}
+
+//release cancels the context of a job, caller must hold the lock
+func (r *JobRegistry) release(id uuid.UUID) {
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+		delete(r.cancels, id)
+	}
+}
+
+//update applies fn to the job with the given id, if it exists
+func (r *JobRegistry) update(id uuid.UUID, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+	}
+}
@@ -0,0 +1,87 @@
+package workpool
+
+import (
+	gocontext "context"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestJobRegistryExpiry(t *testing.T) {
+	r := NewJobRegistry()
+	finished, running, readded := uuid.New(), uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{finished, running, readded} {
+		r.Add(gocontext.Background(), id)
+	}
+	r.Fail(finished, errors.New("failed"))
+	r.Start(running)
+	r.Succeed(readded, "key")
+	//a finished job which is added again, e.g. a replayed dead letter, must not expire by its old entry
+	r.Add(gocontext.Background(), readded)
+
+	r.expire(time.Now().Add(JobRetention + time.Second))
+	if _, ok := r.Get(finished); ok {
+		t.Fatal("finished job is not expired")
+	}
+	for _, id := range []uuid.UUID{running, readded} {
+		if _, ok := r.Get(id); !ok {
+			t.Fatalf("unfinished job %s is expired", id)
+		}
+	}
+	if len(r.finished) != 0 {
+		t.Fatalf("%d expired entries are left in the list", len(r.finished))
+	}
+}
+
+func TestJobRegistryClaim(t *testing.T) {
+	r := NewJobRegistry()
+	first, second := uuid.New(), uuid.New()
+	if !r.Claim("app/1.0.0", first) {
+		t.Fatal("free key cannot be claimed")
+	}
+	r.Add(gocontext.Background(), first)
+	if r.Claim("app/1.0.0", second) {
+		t.Fatal("key claimed by an unfinished job is claimed again")
+	}
+
+	r.Succeed(first, "app/1.0.0")
+	if !r.Claim("app/1.0.0", second) {
+		t.Fatal("key is not released when the job finishes")
+	}
+	r.Unclaim(second)
+	if !r.Claim("app/1.0.0", first) {
+		t.Fatal("key is not released by unclaim")
+	}
+}
+
+func TestJobRegistryCancelRunning(t *testing.T) {
+	r := NewJobRegistry()
+	for _, test := range []struct {
+		name   string
+		finish func(id uuid.UUID)
+		status JobStatus
+		key    string
+	}{
+		//handler has given up
+		{"cancelled", func(id uuid.UUID) { r.Cancelled(id) }, JobCancelled, ""},
+		//handler has ignored the context and finished the work, client must learn that the record is written
+		{"succeeded", func(id uuid.UUID) { r.Succeed(id, "app/1.0.0") }, JobSucceeded, "app/1.0.0"},
+		{"failed", func(id uuid.UUID) { r.Fail(id, errors.New("failed")) }, JobFailed, ""},
+	} {
+		id := uuid.New()
+		ctx := r.Add(gocontext.Background(), id)
+		r.Start(id)
+		if _, err := r.Cancel(id); err != nil {
+			t.Fatal(err)
+		}
+		if ctx.Err() == nil {
+			t.Fatalf("%s: context of the cancelled job is not canceled", test.name)
+		}
+
+		test.finish(id)
+		if job, _ := r.Get(id); job.Status != test.status || job.Key != test.key {
+			t.Fatalf("%s: cancelled job is %s with key %q, expected %s with key %q", test.name, job.Status, job.Key, test.status, test.key)
+		}
+	}
+}
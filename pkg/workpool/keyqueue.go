@@ -0,0 +1,98 @@
+package workpool
+
+import (
+	"sync"
+)
+
+//keyQueues keeps the works of the same key in submission order.
+//Only the first work of a key goes into the work queue, works submitted for the same key while it is in flight
+//wait behind it in the queue of the key. When a work is finished, the next work of its key is released
+//and dispatched before the works in the lanes, since it has already waited for its turn.
+//So writes to the same record are applied in the order they are submitted, whatever their priority is,
+//while works of different keys are processed in parallel. Works without key are not ordered.
+type keyQueues struct {
+	mu sync.Mutex
+
+	//a key is present while a work of the key is in flight, its value is the works waiting behind it
+	keys     map[string][]WorkRequest
+	released []WorkRequest
+	waiting  int
+}
+
+func newKeyQueues() *keyQueues {
+	return &keyQueues{keys: make(map[string][]WorkRequest)}
+}
+
+//enter reports whether the work can go into the work queue.
+//Otherwise the work waits behind the work of the same key which is in flight.
+func (q *keyQueues) enter(work WorkRequest) bool {
+	if work.Key == "" {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting, ok := q.keys[work.Key]
+	if !ok {
+		q.keys[work.Key] = nil
+		return true
+	}
+	q.keys[work.Key] = append(waiting, work)
+	q.waiting++
+	return false
+}
+
+//leave is called when the work in flight with the given key is finished or could not be admitted.
+//It reports whether the next work of the key is released.
+func (q *keyQueues) leave(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	waiting, ok := q.keys[key]
+	if !ok {
+		return false
+	}
+	if len(waiting) == 0 {
+		delete(q.keys, key)
+		return false
+	}
+	q.released = append(q.released, waiting[0])
+	q.keys[key] = waiting[1:]
+	return true
+}
+
+//next takes the oldest released work, it returns false if there is none
+func (q *keyQueues) next() (WorkRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.released) == 0 {
+		return WorkRequest{}, false
+	}
+	work := q.released[0]
+	q.released = q.released[1:]
+	q.waiting--
+	return work, true
+}
+
+//len returns the number of works waiting for their key, released ones included
+func (q *keyQueues) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting
+}
+
+//drain removes and returns the waiting works, released ones first and then the others in order of their keys
+func (q *keyQueues) drain() []WorkRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	works := q.released
+	for _, waiting := range q.keys {
+		works = append(works, waiting...)
+	}
+	q.keys = make(map[string][]WorkRequest)
+	q.released = nil
+	q.waiting = 0
+	return works
+}
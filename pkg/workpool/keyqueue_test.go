@@ -0,0 +1,110 @@
+package workpool
+
+import (
+	gocontext "context"
+	"github.com/google/uuid"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyQueues(t *testing.T) {
+	q := newKeyQueues()
+	if !q.enter(WorkRequest{}) || !q.enter(WorkRequest{}) {
+		t.Fatal("work without key waits")
+	}
+	if !q.enter(WorkRequest{Key: "a", RequestID: "a1"}) || !q.enter(WorkRequest{Key: "b", RequestID: "b1"}) {
+		t.Fatal("first work of a key waits")
+	}
+	for _, id := range []string{"a2", "a3"} {
+		if q.enter(WorkRequest{Key: "a", RequestID: id}) {
+			t.Fatalf("%s does not wait for the work of its key in flight", id)
+		}
+	}
+	if q.len() != 2 {
+		t.Fatalf("%d works are waiting, expected 2", q.len())
+	}
+	if _, ok := q.next(); ok {
+		t.Fatal("work is released while its key is in flight")
+	}
+
+	//works of the key are released one by one in submission order
+	for _, id := range []string{"a2", "a3"} {
+		if !q.leave("a") {
+			t.Fatalf("%s is not released", id)
+		}
+		if work, ok := q.next(); !ok || work.RequestID != id {
+			t.Fatalf("%s is released, expected %s", work.RequestID, id)
+		}
+	}
+	if q.leave("a") || q.leave("b") {
+		t.Fatal("work is released for a key without waiting works")
+	}
+	if !q.enter(WorkRequest{Key: "a", RequestID: "a4"}) {
+		t.Fatal("key is not free after its last work is finished")
+	}
+
+	q.enter(WorkRequest{Key: "a", RequestID: "a5"})
+	q.enter(WorkRequest{Key: "a", RequestID: "a6"})
+	q.leave("a")
+	works := q.drain()
+	if len(works) != 2 || works[0].RequestID != "a5" || works[1].RequestID != "a6" || q.len() != 0 {
+		t.Fatalf("drain returns %v and leaves %d works", works, q.len())
+	}
+}
+
+func TestWorksOfSameKeyInOrder(t *testing.T) {
+	const (
+		keys  = 3
+		works = 10
+	)
+	d := testDispatcher(t, 4)
+
+	var mu sync.Mutex
+	processed := make(map[string][]string)
+	inFlight := make(map[string]int)
+	d.Handlers.Register("record", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error {
+		mu.Lock()
+		inFlight[work.Key]++
+		if inFlight[work.Key] > 1 {
+			t.Errorf("works of key %s run at the same time", work.Key)
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		inFlight[work.Key]--
+		processed[work.Key] = append(processed[work.Key], work.RequestID)
+		mu.Unlock()
+		return nil
+	}))
+
+	//later works have higher priorities, they must still wait for the earlier works of their key
+	var ids []uuid.UUID
+	for i := 0; i < works; i++ {
+		for k := 0; k < keys; k++ {
+			id := submit(t, d, WorkRequest{Type: "record", Key: "key-" + strconv.Itoa(k), RequestID: strconv.Itoa(i),
+				Priority: Priorities[len(Priorities)-1-i%len(Priorities)]})
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range ids {
+		waitJob(t, d, id)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for k := 0; k < keys; k++ {
+		key := "key-" + strconv.Itoa(k)
+		if len(processed[key]) != works {
+			t.Fatalf("%d works of %s are processed, expected %d", len(processed[key]), key, works)
+		}
+		for i, id := range processed[key] {
+			if id != strconv.Itoa(i) {
+				t.Fatalf("works of %s are processed in order %v", key, processed[key])
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package workpool
+
+import (
+	"../model"
+	"gopkg.in/yaml.v2"
+)
+
+//applyMergePatch applies a JSON Merge Patch (RFC 7386) document to the given metadata.
+//Since yaml is a superset of json, patch can be given in either format and
+//the same merge rules are used for yaml: objects are merged recursively,
+//null removes the field and any other value replaces the field.
+func applyMergePatch(current model.Metadata, patch []byte) (model.Metadata, error) {
+
+	var patchDoc interface{}
+	if err := yaml.Unmarshal(patch, &patchDoc); err != nil {
+		return current, err
+	}
+
+	currentBytes, err := yaml.Marshal(current)
+	if err != nil {
+		return current, err
+	}
+	var currentDoc interface{}
+	if err := yaml.Unmarshal(currentBytes, &currentDoc); err != nil {
+		return current, err
+	}
+
+	mergedBytes, err := yaml.Marshal(mergeDocs(currentDoc, patchDoc))
+	if err != nil {
+		return current, err
+	}
+	var merged model.Metadata
+	if err := yaml.UnmarshalStrict(mergedBytes, &merged); err != nil {
+		return current, err
+	}
+	return merged, nil
+}
+
+//mergeDocs merges patch into target following RFC 7386 rules
+func mergeDocs(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[interface{}]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetMap, ok := target.(map[interface{}]interface{})
+	if !ok {
+		targetMap = make(map[interface{}]interface{})
+	}
+	for key, val := range patchMap {
+		if val == nil {
+			delete(targetMap, key)
+			continue
+		}
+		targetMap[key] = mergeDocs(targetMap[key], val)
+	}
+	return targetMap
+}
@@ -0,0 +1,243 @@
+package workpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ScaleDecision is a change of the number of workers made by the autoscaler
+type ScaleDecision struct {
+	Time   time.Time `json:"time" yaml:"time"`
+	From   int       `json:"from" yaml:"from"`
+	To     int       `json:"to" yaml:"to"`
+	Reason string    `json:"reason" yaml:"reason"`
+}
+
+//PoolMetrics is a snapshot of the work pool metrics.
+//Average wait is the time works spend in the work queue, average processing is the time workers spend on them
+//including retries. Failed counts works failed permanently, panicked, cancelled while running, timed out
+//or dead-lettered. DeadLetters is the current size of the dead-letter queue, LaneDepths are the queue depths by priority.
+//KeyWaiting is the number of works waiting for the work in flight with the same key.
+//Types are the metrics of the works by job type.
+type PoolMetrics struct {
+	Workers         int                     `json:"workers" yaml:"workers"`
+	BusyWorkers     int                     `json:"busyWorkers" yaml:"busyWorkers"`
+	QueueDepth      int                     `json:"queueDepth" yaml:"queueDepth"`
+	LaneDepths      map[string]int          `json:"laneDepths,omitempty" yaml:"laneDepths,omitempty"`
+	KeyWaiting      int                     `json:"keyWaiting" yaml:"keyWaiting"`
+	InFlight        int                     `json:"inFlight" yaml:"inFlight"`
+	Processed       uint64                  `json:"processed" yaml:"processed"`
+	Failed          uint64                  `json:"failed" yaml:"failed"`
+	Retries         uint64                  `json:"retries" yaml:"retries"`
+	DeadLettered    uint64                  `json:"deadLettered" yaml:"deadLettered"`
+	DeadLetters     int                     `json:"deadLetters" yaml:"deadLetters"`
+	Panics          uint64                  `json:"panics" yaml:"panics"`
+	Cancelled       uint64                  `json:"cancelled" yaml:"cancelled"`
+	TimedOut        uint64                  `json:"timedOut" yaml:"timedOut"`
+	AvgWaitMs       float64                 `json:"avgWaitMs" yaml:"avgWaitMs"`
+	AvgProcessingMs float64                 `json:"avgProcessingMs" yaml:"avgProcessingMs"`
+	ScaleUps        uint64                  `json:"scaleUps" yaml:"scaleUps"`
+	ScaleDowns      uint64                  `json:"scaleDowns" yaml:"scaleDowns"`
+	LastScale       *ScaleDecision          `json:"lastScale,omitempty" yaml:"lastScale,omitempty"`
+	Types           map[JobType]TypeMetrics `json:"types,omitempty" yaml:"types,omitempty"`
+}
+
+//TypeMetrics are the metrics of the works of a job type
+type TypeMetrics struct {
+	Processed       uint64  `json:"processed" yaml:"processed"`
+	Failed          uint64  `json:"failed" yaml:"failed"`
+	AvgProcessingMs float64 `json:"avgProcessingMs" yaml:"avgProcessingMs"`
+}
+
+//typeCounters count the works of a job type
+type typeCounters struct {
+	processed  uint64
+	failed     uint64
+	processSum time.Duration
+}
+
+//Metrics counts what happens in the work pool. It is shared by dispatcher, workers and autoscaler,
+//server reads a snapshot of it. Gauges and counters are atomic, durations are guarded by mu.
+type Metrics struct {
+	workers  int32
+	busy     int32
+	peakBusy int32
+
+	mu         sync.Mutex
+	processed  uint64
+	failed     uint64
+	retries    uint64
+	dead       uint64
+	panics     uint64
+	cancels    uint64
+	timeouts   uint64
+	waitSum    time.Duration
+	processSum time.Duration
+	scaleUps   uint64
+	scaleDowns uint64
+	lastScale  *ScaleDecision
+	types      map[JobType]*typeCounters
+
+	//wait of the works started since the last takeWait, used by autoscaler
+	windowWait  time.Duration
+	windowCount int
+}
+
+//NewMetrics creates empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{types: make(map[JobType]*typeCounters)}
+}
+
+//Snapshot returns the current metrics. Queue depth, in-flight count and number of dead letters are owned
+//by admission and dead-letter queue, they are filled by the caller.
+func (m *Metrics) Snapshot() PoolMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := PoolMetrics{
+		Workers:      int(atomic.LoadInt32(&m.workers)),
+		BusyWorkers:  int(atomic.LoadInt32(&m.busy)),
+		Processed:    m.processed,
+		Failed:       m.failed,
+		Retries:      m.retries,
+		DeadLettered: m.dead,
+		Panics:       m.panics,
+		Cancelled:    m.cancels,
+		TimedOut:     m.timeouts,
+		ScaleUps:     m.scaleUps,
+		ScaleDowns:   m.scaleDowns,
+	}
+	if finished := m.processed + m.failed; finished > 0 {
+		snapshot.AvgWaitMs = milliseconds(m.waitSum) / float64(finished)
+		snapshot.AvgProcessingMs = milliseconds(m.processSum) / float64(finished)
+	}
+	if m.lastScale != nil {
+		lastScale := *m.lastScale
+		snapshot.LastScale = &lastScale
+	}
+	if len(m.types) > 0 {
+		snapshot.Types = make(map[JobType]TypeMetrics)
+	}
+	for jobType, counters := range m.types {
+		metrics := TypeMetrics{Processed: counters.processed, Failed: counters.failed}
+		if finished := counters.processed + counters.failed; finished > 0 {
+			metrics.AvgProcessingMs = milliseconds(counters.processSum) / float64(finished)
+		}
+		snapshot.Types[jobType] = metrics
+	}
+	return snapshot
+}
+
+//addWorkers changes the number of workers by n
+func (m *Metrics) addWorkers(n int) {
+	atomic.AddInt32(&m.workers, int32(n))
+}
+
+//started is called when a worker picks a work which has waited in the work queue for wait
+func (m *Metrics) started(wait time.Duration) {
+	busy := atomic.AddInt32(&m.busy, 1)
+	for {
+		peak := atomic.LoadInt32(&m.peakBusy)
+		if busy <= peak || atomic.CompareAndSwapInt32(&m.peakBusy, peak, busy) {
+			break
+		}
+	}
+
+	m.mu.Lock()
+	m.waitSum += wait
+	m.windowWait += wait
+	m.windowCount++
+	m.mu.Unlock()
+}
+
+//finished is called when a worker finishes a work of the job type it has spent elapsed on
+func (m *Metrics) finished(jobType JobType, elapsed time.Duration, err error) {
+	atomic.AddInt32(&m.busy, -1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters, ok := m.types[jobType]
+	if !ok {
+		counters = &typeCounters{}
+		m.types[jobType] = counters
+	}
+	m.processSum += elapsed
+	counters.processSum += elapsed
+	if err != nil {
+		m.failed++
+		counters.failed++
+	} else {
+		m.processed++
+		counters.processed++
+	}
+}
+
+//retried counts a failed attempt which is going to be retried
+func (m *Metrics) retried() {
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+//deadLettered counts a work moved to the dead-letter queue
+func (m *Metrics) deadLettered() {
+	m.mu.Lock()
+	m.dead++
+	m.mu.Unlock()
+}
+
+//panicked counts a work whose processing has panicked
+func (m *Metrics) panicked() {
+	m.mu.Lock()
+	m.panics++
+	m.mu.Unlock()
+}
+
+//cancelled counts a cancelled work
+func (m *Metrics) cancelled() {
+	m.mu.Lock()
+	m.cancels++
+	m.mu.Unlock()
+}
+
+//timedOut counts a work which could not be processed within its timeout
+func (m *Metrics) timedOut() {
+	m.mu.Lock()
+	m.timeouts++
+	m.mu.Unlock()
+}
+
+//takeWait returns the average wait of the works started since the last call and how many they are
+func (m *Metrics) takeWait() (time.Duration, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wait, count := m.windowWait, m.windowCount
+	m.windowWait, m.windowCount = 0, 0
+	if count == 0 {
+		return 0, 0
+	}
+	return wait / time.Duration(count), count
+}
+
+//takePeakBusy returns the highest number of busy workers since the last call
+func (m *Metrics) takePeakBusy() int {
+	return int(atomic.SwapInt32(&m.peakBusy, atomic.LoadInt32(&m.busy)))
+}
+
+//scaled records a decision of the autoscaler
+func (m *Metrics) scaled(decision ScaleDecision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if decision.To > decision.From {
+		m.scaleUps++
+	} else {
+		m.scaleDowns++
+	}
+	m.lastScale = &decision
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
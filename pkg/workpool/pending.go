@@ -0,0 +1,92 @@
+package workpool
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//SavePending writes WorkRequests which could not be processed before shutdown to the file
+//so that they can be submitted again by the next run. Existing file is replaced atomically.
+func SavePending(path string, works []WorkRequest) error {
+	return writeJSON(path, works)
+}
+
+//LoadPending reads the WorkRequests written by SavePending. Missing file means there is no pending work.
+func LoadPending(path string) ([]WorkRequest, error) {
+	var works []WorkRequest
+	if err := readJSON(path, &works); err != nil {
+		return nil, err
+	}
+	return works, nil
+}
+
+//SaveDeadLetters writes the dead letters to the file so that they are kept by the next run
+func SaveDeadLetters(path string, letters []DeadLetter) error {
+	return writeJSON(path, letters)
+}
+
+//LoadDeadLetters reads the dead letters written by SaveDeadLetters. Missing file means there is no dead letter.
+func LoadDeadLetters(path string) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	if err := readJSON(path, &letters); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+//writeJSON replaces the file with v atomically
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+//readJSON reads the file written by writeJSON into v, v is left as it is if the file does not exist
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//Resubmit submits pending WorkRequests again keeping their ids, so clients can still follow their jobs.
+//Unlike handlers, it waits while the pool is saturated instead of giving up.
+func Resubmit(admission *Admission, jobs *JobRegistry, works []WorkRequest) error {
+	for _, work := range works {
+		work = work.WithContext(jobs.Add(gocontext.Background(), work.ID))
+		for {
+			err := admission.Submit(work)
+			if err == nil {
+				break
+			}
+			if err != ErrSaturated {
+				jobs.Remove(work.ID)
+				return err
+			}
+			time.Sleep(admission.enqueueTimeout)
+		}
+	}
+	return nil
+}
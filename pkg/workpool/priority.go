@@ -0,0 +1,134 @@
+package workpool
+
+import (
+	"../config"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+//Priority defines the lane of the work queue a WorkRequest waits in.
+//Normal is the zero value so that works persisted without priority keep the normal lane.
+type Priority uint8
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+	PriorityBulk
+)
+
+//PriorityStr defines priority names used in headers, configuration and logs
+var PriorityStr = [...]string{
+	"normal",
+	"high",
+	"bulk",
+}
+
+//Priorities are the priority lanes from the highest one
+var Priorities = [...]Priority{PriorityHigh, PriorityNormal, PriorityBulk}
+
+//ErrUnknownPriority is returned when a priority name is not one of PriorityStr
+var ErrUnknownPriority = errors.New("priority must be one of high, normal, bulk")
+
+//String returns the name of the priority, unknown priorities are written by number so that logging them never panics
+func (p Priority) String() string {
+	if int(p) >= len(PriorityStr) {
+		return "Priority(" + strconv.Itoa(int(p)) + ")"
+	}
+	return PriorityStr[p]
+}
+
+//MarshalText writes priority by name so that persisted works do not depend on constant order
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+//UnmarshalText reads priority name written by MarshalText
+func (p *Priority) UnmarshalText(text []byte) error {
+	priority, err := ParsePriority(string(text))
+	if err != nil {
+		return err
+	}
+	*p = priority
+	return nil
+}
+
+//ParsePriority returns the priority with the given name
+func ParsePriority(name string) (Priority, error) {
+	for i, s := range PriorityStr {
+		if s == name {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNormal, ErrUnknownPriority
+}
+
+//Above reports whether p is a higher priority than q
+func (p Priority) Above(q Priority) bool {
+	return p.lane() < q.lane()
+}
+
+//lane returns the index of the priority in Priorities, unknown priorities use the normal lane
+func (p Priority) lane() int {
+	for i, priority := range Priorities {
+		if priority == p {
+			return i
+		}
+	}
+	return PriorityNormal.lane()
+}
+
+//scheduler picks the lane the next work is taken from by smooth weighted round robin:
+//every non-empty lane earns its weight, the lane with the most credit is picked and pays the sum of the weights.
+//So lanes are served in proportion to their weights and a lower lane still gets its share while higher ones are busy.
+//Empty lanes do not save credit for later. Only the dispatching goroutine calls next.
+type scheduler struct {
+	lanes  []chan WorkRequest
+	credit []int
+
+	mu      sync.Mutex
+	weights []int
+}
+
+func newScheduler(lanes []chan WorkRequest, weights config.PriorityWeights) *scheduler {
+	s := &scheduler{
+		lanes:  lanes,
+		credit: make([]int, len(lanes)),
+	}
+	s.setWeights(weights)
+	return s
+}
+
+//setWeights changes the weights of the lanes
+func (s *scheduler) setWeights(weights config.PriorityWeights) {
+	s.mu.Lock()
+	s.weights = []int{weights.High, weights.Normal, weights.Bulk}
+	s.mu.Unlock()
+}
+
+//next takes a work from the lanes without blocking, it returns false if all lanes are empty
+func (s *scheduler) next() (WorkRequest, bool) {
+	s.mu.Lock()
+	weights := s.weights
+	s.mu.Unlock()
+
+	picked, total := -1, 0
+	for i, lane := range s.lanes {
+		if len(lane) == 0 {
+			s.credit[i] = 0
+			continue
+		}
+		s.credit[i] += weights[i]
+		total += weights[i]
+		if picked < 0 || s.credit[i] > s.credit[picked] {
+			picked = i
+		}
+	}
+	if picked < 0 {
+		return WorkRequest{}, false
+	}
+	s.credit[picked] -= total
+
+	//lane is not empty and dispatching goroutine is the only one taking works from the lanes
+	return <-s.lanes[picked], true
+}
@@ -0,0 +1,83 @@
+package workpool
+
+import (
+	"../config"
+	"testing"
+)
+
+//testLanes returns lanes in the order of Priorities, each filled with the given number of works of its priority
+func testLanes(works int) []chan WorkRequest {
+	var lanes []chan WorkRequest
+	for _, priority := range Priorities {
+		lane := make(chan WorkRequest, works)
+		for i := 0; i < works; i++ {
+			lane <- WorkRequest{Priority: priority}
+		}
+		lanes = append(lanes, lane)
+	}
+	return lanes
+}
+
+//take takes n works from the scheduler and counts them by priority
+func take(t *testing.T, s *scheduler, n int) map[Priority]int {
+	counts := make(map[Priority]int)
+	for i := 0; i < n; i++ {
+		work, ok := s.next()
+		if !ok {
+			t.Fatalf("lanes are empty after %d works", i)
+		}
+		counts[work.Priority]++
+	}
+	return counts
+}
+
+func TestSchedulerWeightedFairness(t *testing.T) {
+	s := newScheduler(testLanes(100), config.PriorityWeights{High: 6, Normal: 3, Bulk: 1})
+
+	//every round of the sum of the weights serves each lane as many times as its weight
+	for round := 0; round < 5; round++ {
+		counts := take(t, s, 10)
+		if counts[PriorityHigh] != 6 || counts[PriorityNormal] != 3 || counts[PriorityBulk] != 1 {
+			t.Fatalf("round %d serves %v, expected high 6, normal 3, bulk 1", round, counts)
+		}
+	}
+
+	//new weights are applied from the next work
+	s.setWeights(config.PriorityWeights{High: 1, Normal: 1, Bulk: 2})
+	counts := take(t, s, 20)
+	if counts[PriorityHigh] != 5 || counts[PriorityNormal] != 5 || counts[PriorityBulk] != 10 {
+		t.Fatalf("changed weights serve %v, expected high 5, normal 5, bulk 10", counts)
+	}
+}
+
+func TestSchedulerEmptyLanes(t *testing.T) {
+	lanes := testLanes(20)
+	s := newScheduler(lanes, config.PriorityWeights{High: 6, Normal: 3, Bulk: 1})
+
+	//lanes left share in proportion to their weights
+	for len(lanes[0]) > 0 {
+		<-lanes[0]
+	}
+	counts := take(t, s, 8)
+	if counts[PriorityNormal] != 6 || counts[PriorityBulk] != 2 {
+		t.Fatalf("normal and bulk lanes are served %v, expected 6 and 2", counts)
+	}
+
+	//an empty lane does not save credit, so it does not take several works in a row when it is filled again
+	lanes[0] <- WorkRequest{Priority: PriorityHigh}
+	lanes[0] <- WorkRequest{Priority: PriorityHigh}
+	counts = take(t, s, 3)
+	if counts[PriorityHigh] != 2 {
+		t.Fatalf("refilled high lane is served %v", counts)
+	}
+
+	for len(lanes[1]) > 0 {
+		<-lanes[1]
+	}
+	for len(lanes[2]) > 0 {
+		<-lanes[2]
+	}
+	if work, ok := s.next(); ok {
+		t.Fatalf("work of %s is taken from empty lanes", work.Priority)
+	}
+}
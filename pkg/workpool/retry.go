@@ -0,0 +1,60 @@
+package workpool
+
+import (
+	"../config"
+	"../memstore"
+	gocontext "context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+//permanentError marks a failure which does not go away by retrying, e.g. an invalid patch
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+//Permanent marks err as not retryable, handlers use it for failures which do not go away by retrying
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+//retryable reports whether the work may succeed if it is processed again.
+//Conflicts, missing records, invalid changes and unsupported values are permanent, so are cancellation
+//and timeout of the job. Anything else (e.g. an I/O error of durable storage) is considered transient.
+func retryable(err error) bool {
+	var p permanentError
+	switch {
+	case errors.As(err, &p),
+		errors.Is(err, memstore.ErrConflict),
+		errors.Is(err, memstore.ErrNotFound),
+		errors.Is(err, memstore.ErrUnsupportedValue),
+		errors.Is(err, ErrKeyChanged),
+		errors.Is(err, gocontext.Canceled),
+		errors.Is(err, gocontext.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+//backoff returns how long to wait before the next attempt after the given failed attempt.
+//Delay doubles with every attempt up to MaxBackoff, half of it is random so that retries of
+//works failed at the same time are spread.
+func backoff(cfg config.RetryConfig, attempt int) time.Duration {
+	delay := cfg.MaxBackoff
+	if attempt < 32 {
+		if d := cfg.InitialBackoff << uint(attempt-1); d > 0 && d < cfg.MaxBackoff {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -1,33 +1,67 @@
 package workpool
 
 import (
+	"../config"
 	"../context"
 	"../logger"
+	gocontext "context"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"runtime/debug"
+	"time"
 )
 
+//PanicError is the failure of a work whose processing has panicked, Stack is where it panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("work panicked: %v", e.Value)
+}
+
 //Worker defines a worker unit which can be assigned "Work"
 //through its work channel where worker can pick it up.
 //worker should also be aware of workerQueue so that
 //it can notify it whenever it is available for the next work
 //Worker has also an ID and access to context so that it can use
-//storage and logger.
+//storage and logger. Work is processed by the handler of its job type. Progress of the work is reported to the job registry
+//and the in-flight slot is released to admission when the work is done. Wait and processing times
+//are counted in the metrics of the pool and works failed after all attempts go to the dead-letter queue.
+//A panic while processing a work fails only that job, worker goes on with a new goroutine.
+//job and started belong to the goroutine of the worker, they are what has to be cleaned up after a panic.
 type Worker struct {
 	workerQueue chan chan WorkRequest
 	work        chan WorkRequest
+	admission   *Admission
+	jobs        *JobRegistry
+	metrics     *Metrics
+	deadLetters *DeadLetterQueue
+	handlers    *Handlers
 	Ctx         *context.AppContext
-	quit        chan bool
+	quit        chan struct{}
 	ID          uuid.UUID
+	log         *logger.AsyncLogger
+	job         *WorkRequest
+	started     time.Time
 }
 
 //NewWorker creates a worker instance
-func NewWorker(workerQueue chan chan WorkRequest, ctx *context.AppContext) *Worker {
+func NewWorker(workerQueue chan chan WorkRequest, admission *Admission, jobs *JobRegistry, metrics *Metrics, deadLetters *DeadLetterQueue, handlers *Handlers, ctx *context.AppContext) *Worker {
 	return &Worker{
 		workerQueue: workerQueue,
 		work:        make(chan WorkRequest),
-		quit:        make(chan bool),
+		admission:   admission,
+		jobs:        jobs,
+		metrics:     metrics,
+		deadLetters: deadLetters,
+		handlers:    handlers,
+		quit:        make(chan struct{}),
 		Ctx:         ctx,
 		ID:          uuid.New(),
+		log:         ctx.Logger.Component("workpool"),
 	}
 }
 
@@ -36,20 +70,28 @@ func NewWorker(workerQueue chan chan WorkRequest, ctx *context.AppContext) *Work
 //So whenever worker queue has a work item to be able to work on it,
 //it has been assigned to worker's work channel by dispatcher so that
 //worker can pick it up and start working on that.
+//A panic anywhere in the goroutine is recovered by recoverPanic which starts a new goroutine for the worker.
 func (w *Worker) start() {
 	go func() {
+		defer w.recoverPanic()
 
 		for {
 			//registers itself to worker queue
-			w.workerQueue <- w.work
-			w.Ctx.Logger.Log(logger.INFO, "Worker ", w.ID.String(), " registered its own chan WorkRequest to worker queue to say it is available")
+			w.log.Log(logger.INFO, "Worker ", w.ID.String(), " registers its own chan WorkRequest to worker queue to say it is available")
+			select {
+			case w.workerQueue <- w.work:
+			case <-w.quit:
+				return
+			}
 
 			//after notifiying worker queue about availability, waits for an assignment by its own work queue
 
 			select {
 			case job := <-w.work:
-				w.Ctx.Logger.Log(logger.INFO, "Work ", job.ID.String(), " has been assigned to worker s queue.")
-				w.Ctx.Storage.Insert(job.Payload.Version, job.Payload)
+				w.job = &job
+				w.handle(job)
+				w.admission.Finish(job)
+				w.job = nil
 
 			case <-w.quit:
 				return
@@ -59,7 +101,140 @@ func (w *Worker) start() {
 	}()
 }
 
+//recoverPanic is deferred by the goroutine of the worker. A panic fails only the job being processed,
+//it is recorded with the stack where it panicked, the in-flight slot of the work is released
+//and the worker goes on with a new goroutine which registers itself to worker queue again.
+func (w *Worker) recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	defer w.start()
+
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	w.metrics.panicked()
+	if w.job == nil {
+		w.log.LogFields(logger.ERROR, "Worker panicked", logger.F("worker", w.ID.String()),
+			logger.F("panic", fmt.Sprint(err.Value)), logger.F("stack", string(err.Stack)))
+		return
+	}
+
+	job := *w.job
+	w.job = nil
+	if !w.started.IsZero() {
+		w.metrics.finished(job.JobType(), time.Since(w.started), err)
+		w.started = time.Time{}
+	}
+	w.log.LogFields(logger.ERROR, "Work panicked", logger.F("job", job.ID), logger.RequestID(job.RequestID),
+		logger.F("worker", w.ID.String()), logger.F("type", job.JobType()), logger.F("key", job.Key),
+		logger.F("panic", fmt.Sprint(err.Value)), logger.F("stack", string(err.Stack)))
+	w.jobs.Fail(job.ID, err)
+	w.admission.Finish(job)
+}
+
+//handle processes the WorkRequest and records the result.
+//Work is processed within its timeout and it is given up when its job is cancelled.
+//Transient failures are retried with backoff, works still failing after the last attempt
+//are moved to the dead-letter queue. Permanent failures fail the job at once, panics are left to recoverPanic.
+func (w *Worker) handle(job WorkRequest) {
+	jobLogger := w.log.With(logger.F("job", job.ID), logger.RequestID(job.RequestID))
+	jobLogger.Log(logger.INFO, "Work has been assigned to worker s queue.")
+	ctx := job.Context()
+	if ctx.Err() != nil {
+		jobLogger.Log(logger.WARNING, "Work has been cancelled before it started")
+		w.jobs.Cancelled(job.ID)
+		w.metrics.cancelled()
+		return
+	}
+
+	w.jobs.Start(job.ID)
+	w.started = time.Now()
+	w.metrics.started(w.started.Sub(job.Enqueued))
+
+	timeout := job.Timeout
+	if timeout <= 0 {
+		timeout = w.config().JobTimeout
+	}
+	ctx, cancel := gocontext.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	attempt, err := w.run(ctx, job, jobLogger)
+	w.metrics.finished(job.JobType(), time.Since(w.started), err)
+	w.started = time.Time{}
+
+	switch {
+	case err == nil:
+		w.jobs.Succeed(job.ID, job.Key)
+
+	case errors.Is(err, gocontext.Canceled):
+		jobLogger.LogFields(logger.WARNING, "Work has been cancelled", logger.F("type", job.JobType()), logger.F("key", job.Key))
+		w.jobs.Cancelled(job.ID)
+		w.metrics.cancelled()
+
+	case errors.Is(err, gocontext.DeadlineExceeded):
+		jobLogger.LogFields(logger.ERROR, "Work has timed out", logger.F("type", job.JobType()), logger.F("key", job.Key),
+			logger.F("timeout", timeout), logger.F("attempts", attempt))
+		w.jobs.Fail(job.ID, ErrJobTimedOut)
+		w.metrics.timedOut()
+
+	case retryable(err):
+		jobLogger.LogFields(logger.ERROR, "Work failed after all attempts, it is moved to dead-letter queue",
+			logger.F("type", job.JobType()), logger.F("key", job.Key), logger.F("attempts", attempt), logger.Err(err))
+		w.jobs.DeadLetter(job.ID, err)
+		w.metrics.deadLettered()
+		dropped, ok := w.deadLetters.Add(DeadLetter{Work: job, Attempts: attempt, Error: err.Error(), DeadLetteredAt: time.Now()})
+		if ok {
+			w.log.LogFields(logger.ERROR, "Dead-letter queue is full, oldest work is dropped",
+				logger.F("job", dropped.Work.ID), logger.F("type", dropped.Work.JobType()), logger.F("key", dropped.Work.Key))
+		}
+
+	default:
+		jobLogger.LogFields(logger.ERROR, "Work failed", logger.F("type", job.JobType()), logger.F("key", job.Key), logger.Err(err))
+		w.jobs.Fail(job.ID, err)
+	}
+}
+
+//run processes the WorkRequest until it succeeds, fails permanently or runs out of attempts.
+//It returns the number of attempts and the last error.
+func (w *Worker) run(ctx gocontext.Context, job WorkRequest, jobLogger *logger.AsyncLogger) (attempt int, err error) {
+	retry := w.config().Retry
+	for attempt = 1; ; attempt++ {
+		if err = w.process(ctx, job); err == nil || !retryable(err) || attempt >= retry.MaxAttempts {
+			return attempt, err
+		}
+		delay := backoff(retry, attempt)
+		jobLogger.LogFields(logger.WARNING, "Work failed, it will be retried",
+			logger.F("attempt", attempt), logger.F("delay", delay), logger.Err(err))
+		w.jobs.Retry(job.ID, err)
+		w.metrics.retried()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		}
+	}
+}
+
+//config returns the current work pool settings, they can be changed by reloading configuration
+func (w *Worker) config() config.WorkPoolConfig {
+	return workPoolConfig(w.Ctx)
+}
+
+//process processes the WorkRequest by the handler of its type, handler gives up when ctx is done
+func (w *Worker) process(ctx gocontext.Context, job WorkRequest) error {
+	handler, ok := w.handlers.Handler(job.JobType())
+	if !ok {
+		return Permanent(fmt.Errorf("%w %s", ErrUnknownJobType, job.JobType()))
+	}
+	return handler.Handle(ctx, job)
+}
+
 //stop terminates that worker so that it no task picked by it.
+//Worker returns when it is idle, also if it is still waiting to register itself to worker queue.
+//It is called once for a worker, by the dispatching goroutine or after dispatching is stopped.
 func (w *Worker) stop() {
-	go func() { w.quit <- true }()
+	close(w.quit)
 }
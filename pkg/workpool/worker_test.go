@@ -0,0 +1,195 @@
+package workpool
+
+import (
+	"../config"
+	"../context"
+	"../logger"
+	"../memstore"
+	gocontext "context"
+	"fmt"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testDispatcher starts a pool of the given number of workers which is stopped when the test ends
+func testDispatcher(t *testing.T, workers int) *Dispatcher {
+	log := logger.CreateAsyncLogger()
+	for level := range logger.LogLevelStr {
+		log.SetSink(logger.LogLevel(level), ioutil.Discard)
+	}
+	ctx := &context.AppContext{Storage: memstore.CreateInMemDB(), Logger: log}
+	d := NewDispatcher(NewAdmission(10, 100, time.Second), workers, NewJobRegistry(), ctx)
+	d.StartDispatcher()
+	t.Cleanup(func() {
+		d.Stop(gocontext.Background())
+	})
+	return d
+}
+
+//submit submits a work of the given type and returns the id of its job
+func submit(t *testing.T, d *Dispatcher, work WorkRequest) uuid.UUID {
+	work.ID = uuid.New()
+	work = work.WithContext(d.Jobs.Add(gocontext.Background(), work.ID))
+	if err := d.Admission.Submit(work); err != nil {
+		t.Fatal(err)
+	}
+	return work.ID
+}
+
+//waitJob waits until the job is finished and returns it
+func waitJob(t *testing.T, d *Dispatcher, id uuid.UUID) Job {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := d.Jobs.Get(id); ok && job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s is not finished", id)
+	return Job{}
+}
+
+func TestPanickingHandler(t *testing.T) {
+	const workers = 2
+	d := testDispatcher(t, workers)
+	d.Handlers.Register("panic", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error {
+		panic("handler is broken")
+	}))
+	running, release := make(chan struct{}), make(chan struct{})
+	d.Handlers.Register("block", HandlerFunc(func(ctx gocontext.Context, work WorkRequest) error {
+		running <- struct{}{}
+		<-release
+		return nil
+	}))
+
+	for i := 0; i < workers; i++ {
+		job := waitJob(t, d, submit(t, d, WorkRequest{Type: "panic"}))
+		if job.Status != JobFailed || !strings.Contains(job.Error, "handler is broken") {
+			t.Fatalf("job of panicking handler is %s with error %q", job.Status, job.Error)
+		}
+	}
+	metrics := d.Metrics.Snapshot()
+	if metrics.Panics != workers || metrics.Types["panic"].Failed != workers {
+		t.Fatalf("panics are not counted: %d panics, %d failed", metrics.Panics, metrics.Types["panic"].Failed)
+	}
+	if d.Workers() != workers {
+		t.Fatalf("pool has %d workers after panics, expected %d", d.Workers(), workers)
+	}
+
+	//every worker must still take works, so all of them run at the same time
+	var ids []uuid.UUID
+	for i := 0; i < workers; i++ {
+		ids = append(ids, submit(t, d, WorkRequest{Type: "block"}))
+	}
+	for i := 0; i < workers; i++ {
+		select {
+		case <-running:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d workers are working after panics", i, workers)
+		}
+	}
+	close(release)
+	for _, id := range ids {
+		if job := waitJob(t, d, id); job.Status != JobSucceeded {
+			t.Fatalf("job is %s after panics: %s", job.Status, job.Error)
+		}
+	}
+	if d.Admission.InFlight() != 0 {
+		t.Fatalf("%d in-flight slots are not released", d.Admission.InFlight())
+	}
+}
+
+func TestUnknownOperation(t *testing.T) {
+	d := testDispatcher(t, 1)
+	job := waitJob(t, d, submit(t, d, WorkRequest{Op: Operation(42), Priority: Priority(42)}))
+	if job.Status != JobFailed || !strings.Contains(job.Error, ErrUnknownJobType.Error()) {
+		t.Fatalf("job of unknown operation is %s with error %q", job.Status, job.Error)
+	}
+}
+
+func TestWorkerQueueSize(t *testing.T) {
+	log := logger.CreateAsyncLogger()
+	c := config.Default()
+	c.WorkPool.Autoscale.MaxWorkers = 8
+	ctx := &context.AppContext{Logger: log, Config: config.NewStore(c, nil, log)}
+	d := NewDispatcher(NewAdmission(10, 100, time.Second), 2, NewJobRegistry(), ctx)
+	if cap(d.WorkerQueue) != 8 {
+		t.Fatalf("worker queue has room for %d workers, autoscaling can start 8", cap(d.WorkerQueue))
+	}
+}
+
+func TestStopWorkerWaitingToRegister(t *testing.T) {
+	log := logger.CreateAsyncLogger()
+	ctx := &context.AppContext{Logger: log}
+	baseline := runtime.NumGoroutine()
+
+	//nobody takes from worker queue, so worker waits to register itself
+	w := NewWorker(make(chan chan WorkRequest), nil, nil, nil, nil, nil, ctx)
+	w.start()
+	w.stop()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatal("stopped worker is still waiting to register")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReloadWorkersBeforeStartAndAfterStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeWorkers := func(workers int) {
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("workpool:\n  workers: %d\n", workers)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//reload must not wait for the dispatcher
+	reload := func(store *config.Store) {
+		done := make(chan error, 1)
+		go func() { done <- store.Reload() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reload is blocked by the dispatcher")
+		}
+	}
+
+	writeWorkers(2)
+	args := []string{"-config", path}
+	c, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.CreateAsyncLogger()
+	for level := range logger.LogLevelStr {
+		log.SetSink(logger.LogLevel(level), ioutil.Discard)
+	}
+	store := config.NewStore(c, args, log)
+	ctx := &context.AppContext{Storage: memstore.CreateInMemDB(), Logger: log, Config: store}
+	d := NewDispatcher(NewAdmission(10, 100, time.Second), c.WorkPool.Workers, NewJobRegistry(), ctx)
+
+	writeWorkers(5)
+	reload(store)
+	d.StartDispatcher()
+	deadline := time.Now().Add(time.Second)
+	for d.Workers() != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool has %d workers, size reloaded before start is 5", d.Workers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := d.Stop(gocontext.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writeWorkers(4)
+	reload(store)
+}
@@ -2,11 +2,142 @@ package workpool
 
 import (
 	"../model"
+	gocontext "context"
+	"errors"
 	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
 )
 
+//Operation defines what a worker does with the WorkRequest
+type Operation uint8
+
+const (
+	OpInsert Operation = iota
+	OpUpdate
+	OpPatch
+	OpDelete
+)
+
+//OperationStr defines operation names used in logs
+var OperationStr = [...]string{
+	"INSERT",
+	"UPDATE",
+	"PATCH",
+	"DELETE",
+}
+
+//String returns the name of the operation, unknown operations are written by number so that logging them never panics
+func (op Operation) String() string {
+	if int(op) >= len(OperationStr) {
+		return "Operation(" + strconv.Itoa(int(op)) + ")"
+	}
+	return OperationStr[op]
+}
+
+//MarshalText writes operation by name so that persisted works do not depend on constant order
+func (op Operation) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+//UnmarshalText reads operation name written by MarshalText
+func (op *Operation) UnmarshalText(text []byte) error {
+	for i, s := range OperationStr {
+		if s == string(text) {
+			*op = Operation(i)
+			return nil
+		}
+	}
+	return errors.New("unknown operation " + string(text))
+}
+
 //WorkRequest defines the work that can be processed by workers.
+//Type is the job type whose handler processes the work, works of record operations have no type but Op.
+//Key is the storage key the operation applies to. Payload is the full record
+//for insert and update, Patch is the raw merge patch document for patch.
+//Data is the raw document of arguments for the other job types, e.g. JSON.
+//Works with the same key are processed in submission order, works without key are not ordered.
+//Priority selects the lane of the work queue the work waits in.
+//Enqueued is set by admission when the work is put into the work queue. Timeout limits the processing
+//of the work, workpool.jobTimeout if zero. Context of the work is not persisted, see WithContext.
+//Works are persisted and shown by admin endpoints in JSON or YAML, operation and priority by name.
 type WorkRequest struct {
-	ID      uuid.UUID
-	Payload model.Metadata
+	ID        uuid.UUID      `json:"id" yaml:"id"`
+	Type      JobType        `json:"type,omitempty" yaml:"type,omitempty"`
+	Op        Operation      `json:"op" yaml:"op"`
+	Key       string         `json:"key" yaml:"key"`
+	Payload   model.Metadata `json:"payload" yaml:"payload,omitempty"`
+	Patch     Document       `json:"patch,omitempty" yaml:"patch,omitempty"`
+	Data      Document       `json:"data,omitempty" yaml:"data,omitempty"`
+	RequestID string         `json:"requestId,omitempty" yaml:"requestId,omitempty"`
+	Priority  Priority       `json:"priority" yaml:"priority"`
+	Enqueued  time.Time      `json:"enqueued" yaml:"enqueued"`
+	Timeout   time.Duration  `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	ctx gocontext.Context
+}
+
+//JobType returns the type of the work, the type of a record operation follows its Op, e.g. insert
+func (w WorkRequest) JobType() JobType {
+	if w.Type != "" {
+		return w.Type
+	}
+	return JobType(strings.ToLower(w.Op.String()))
+}
+
+//Context returns the context of the work, background context if it has none.
+//Context is canceled when the job is cancelled.
+func (w WorkRequest) Context() gocontext.Context {
+	if w.ctx != nil {
+		return w.ctx
+	}
+	return gocontext.Background()
+}
+
+//WithContext returns a copy of the work with the given context, like http.Request.WithContext
+func (w WorkRequest) WithContext(ctx gocontext.Context) WorkRequest {
+	w.ctx = ctx
+	return w
+}
+
+//Detach returns a context which has the values of ctx but is neither canceled with it nor has its deadline.
+//Works outlive the HTTP request they are created by, they keep the values of request context
+//but must not be canceled when the response is written.
+func Detach(ctx gocontext.Context) gocontext.Context {
+	return detached{parent: ctx}
+}
+
+type detached struct {
+	parent gocontext.Context
+}
+
+func (d detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (d detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (d detached) Err() error {
+	return nil
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+//Document is a raw document, e.g. a merge patch, written as text instead of bytes in JSON and YAML
+type Document []byte
+
+//MarshalText writes the document as it is
+func (d Document) MarshalText() ([]byte, error) {
+	return d, nil
+}
+
+//UnmarshalText reads the document written by MarshalText
+func (d *Document) UnmarshalText(text []byte) error {
+	*d = append((*d)[:0], text...)
+	return nil
 }